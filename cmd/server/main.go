@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+
+	fooddiary "github.com/connorkuljis/food-diary"
+	"github.com/connorkuljis/food-diary/server"
+)
+
+func main() {
+	checkTemplates := flag.Bool("check-templates", false, "compile all templates and exit")
+	flag.Parse()
+
+	if *checkTemplates {
+		if err := server.CheckTemplates(fooddiary.FS); err != nil {
+			fatal(err)
+		}
+		slog.Info("views compiled successfully", "count", len(server.Views))
+		return
+	}
+
+	s, err := server.NewServerFromFlags()
+	if err != nil {
+		fatal(err)
+	}
+
+	slog.Info("spinning up server", "url", "http://localhost:"+s.Config.Port)
+
+	if err := http.ListenAndServe(":"+s.Config.Port, s); err != nil {
+		fatal(err)
+	}
+}
+
+// fatal logs err and exits, the slog equivalent of log.Fatal.
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
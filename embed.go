@@ -0,0 +1,9 @@
+// Package fooddiary holds the templates and static files that are compiled
+// into the binary. The server itself lives in package server and the entry
+// point in cmd/server.
+package fooddiary
+
+import "embed"
+
+//go:embed templates/* static/*
+var FS embed.FS
@@ -0,0 +1,60 @@
+package repo
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// AuditTime is a UTC timestamp stored as TEXT, the way SQLite's
+// CURRENT_TIMESTAMP writes it. The driver hands these columns back as strings,
+// so AuditTime parses them when scanning.
+type AuditTime struct {
+	time.Time
+}
+
+// NewAuditTime truncates t to the second, the precision stored in the
+// database.
+func NewAuditTime(t time.Time) AuditTime {
+	return AuditTime{t.UTC().Truncate(time.Second)}
+}
+
+func (t *AuditTime) Scan(src any) error {
+	var str string
+	switch v := src.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v.UTC()
+		return nil
+	case string:
+		str = v
+	case []byte:
+		str = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into AuditTime", src)
+	}
+
+	// rows migrated from before the audit columns existed may be blank
+	if str == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	parsed, err := time.ParseInLocation(Timestamp, str, time.UTC)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+
+	return nil
+}
+
+func (t AuditTime) Value() (driver.Value, error) {
+	if t.IsZero() {
+		return nil, nil
+	}
+
+	return t.UTC().Format(Timestamp), nil
+}
@@ -1,36 +1,455 @@
 package repo
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
 	"github.com/jmoiron/sqlx"
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+)
+
+var (
+	db     *sqlx.DB
+	dbPath string
 )
 
-var db *sqlx.DB
+const (
+	DbName     = ".meals.db" // used when falling back to the current directory
+	DbFileName = "meals.db"  // used inside the application data directory
+	AppDirName = "food-diary"
+)
+
+// DefaultQueryTimeout is used when DBConfig.QueryTimeout is not set.
+const DefaultQueryTimeout = 10 * time.Second
+
+// queryTimeout bounds how long a single repo call may run before it is
+// cancelled and context.DeadlineExceeded is returned.
+var queryTimeout = DefaultQueryTimeout
 
-const DbName = ".meals.db"
+// DBConfig holds the settings InitDBWithConfig opens the database with.
+type DBConfig struct {
+	// Path is the database file, see InitDB.
+	Path string
+	// Logger receives every SQL statement when it has debug level enabled.
+	// Nil discards them.
+	Logger *slog.Logger
+	// QueryTimeout bounds each repo call on top of the caller's context. Zero
+	// uses DefaultQueryTimeout.
+	QueryTimeout time.Duration
+}
+
+// InitDB opens the database at path and brings its schema up to date. An empty
+// path uses DefaultDBPath, and ":memory:" opens a throwaway database.
+func InitDB(path string) error {
+	return InitDBWithConfig(DBConfig{Path: path})
+}
+
+// InitDBWithLogger is InitDB, additionally logging every SQL statement and its
+// number of arguments when logger has debug level enabled.
+func InitDBWithLogger(path string, logger *slog.Logger) error {
+	return InitDBWithConfig(DBConfig{Path: path, Logger: logger})
+}
 
-func InitDB() error {
+// InitDBWithConfig is InitDB with the settings in cfg.
+func InitDBWithConfig(cfg DBConfig) error {
 	var err error
 
-	// home, err := os.UserHomeDir()
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
+	path, logger := cfg.Path, cfg.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	queryTimeout = cfg.QueryTimeout
+	if queryTimeout <= 0 {
+		queryTimeout = DefaultQueryTimeout
+	}
 
-	// db, err = sqlx.Connect("sqlite", filepath.Join(home, DbName))
-	db, err = sqlx.Connect("sqlite", DbName)
+	if path == "" {
+		path, err = DefaultDBPath()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ValidateDBPath(path)
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Exec(MealsSchema)
+	// foreign keys are a per connection setting in SQLite, so they are enabled
+	// through the DSN to apply to every connection in the pool
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	dsn := path + sep + "_pragma=foreign_keys(1)"
+	if logger.Enabled(context.Background(), slog.LevelDebug) {
+		db = sqlx.NewDb(sql.OpenDB(loggingConnector{dsn: dsn, driver: &sqlite.Driver{}, logger: logger}), "sqlite")
+		err = db.Ping()
+	} else {
+		db, err = sqlx.Connect("sqlite", dsn)
+	}
 	if err != nil {
 		return err
 	}
+	dbPath = path
+
+	// every connection to :memory: gets its own empty database, so keep one
+	if path == ":memory:" {
+		db.SetMaxOpenConns(1)
+	}
 
-	_, err = db.Exec(UsersSchema)
+	err = createSchema()
 	if err != nil {
 		return err
 	}
+
+	err = MigrateAddPasswordChangeColumn(db)
+	if err != nil {
+		return err
+	}
+
+	err = MigrateAddNutritionColumns(db)
+	if err != nil {
+		return err
+	}
+
+	err = MigrateAddQuantityColumns(db)
+	if err != nil {
+		return err
+	}
+
+	err = MigrateAddNotesColumn(db)
+	if err != nil {
+		return err
+	}
+
+	err = MigrateAddAuditColumns(db)
+	if err != nil {
+		return err
+	}
+
+	err = MigrateAddPhotoPathColumn(db)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// createSchema creates any table that does not exist yet.
+func createSchema() error {
+	for _, schema := range []string{MealsSchema, UsersSchema, TagsSchema, MealTagsSchema} {
+		_, err := db.Exec(schema)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// DropAndRecreateSchema deletes every table and creates them again empty, so
+// that tests sharing the database start from a clean state. It destroys all
+// data and must never be called in production, so it panics unless GO_ENV is
+// "test".
+func DropAndRecreateSchema() error {
+	if os.Getenv("GO_ENV") != "test" {
+		panic("repo.DropAndRecreateSchema called outside of tests, set GO_ENV=test")
+	}
+
+	// children first, so that foreign keys never point at a dropped table
+	for _, table := range []string{"MealTags", "Tags", "Meals", "Users"} {
+		_, err := db.Exec(`DROP TABLE IF EXISTS ` + table)
+		if err != nil {
+			return err
+		}
+	}
+
+	return createSchema()
+}
+
+// ValidateDBPath checks that path can be used as the database file: an existing
+// file must be readable and writable, otherwise its parent directory must exist
+// and be writable. In-memory and URI style paths are not checked.
+func ValidateDBPath(path string) error {
+	if path == ":memory:" || strings.HasPrefix(path, "file:") {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("DB path %s: is a directory", path)
+		}
+
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return fmt.Errorf("DB path %s: file is not readable and writable: %w", path, err)
+		}
+		return f.Close()
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("DB path %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	info, err = os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("DB path %s: parent directory does not exist", path)
+	}
+	if err != nil {
+		return fmt.Errorf("DB path %s: %w", path, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("DB path %s: parent %s is not a directory", path, dir)
+	}
+
+	// the only reliable writability check is to try it
+	f, err := os.CreateTemp(dir, ".db-check-*")
+	if err != nil {
+		return fmt.Errorf("DB path %s: parent directory is not writable", path)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// queryContext returns a child of ctx that also expires after the query
+// timeout.
+func queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, queryTimeout)
+}
+
+// Vacuum checkpoints the write-ahead log, if there is one, and rebuilds the
+// database file to reclaim the space left behind by deleted rows. It can take
+// a while on a large database, so it is not bound by the query timeout.
+func Vacuum() error {
+	_, err := db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`VACUUM`)
+	return err
+}
+
+// WithTransaction runs fn in a transaction, committing it when fn returns nil
+// and rolling it back otherwise. The transaction is bound to ctx and the query
+// timeout, so fn can use the tx methods that do not take a context.
+func WithTransaction(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	return withTransaction(ctx, db, fn)
+}
+
+// withTransaction is WithTransaction on a database other than the package one,
+// bound to ctx as is.
+func withTransaction(ctx context.Context, db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op once committed
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// columnExists reports whether table already has column, so that migrations
+// can safely run on every start up.
+func columnExists(db *sqlx.DB, table, column string) (bool, error) {
+	var count int
+	err := db.Get(&count, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// MigrateAddPasswordChangeColumn adds Users.last_password_change_at to databases
+// created before it existed. SQLite cannot add a column with a non-constant
+// default, so existing rows are backfilled with the current time instead.
+func MigrateAddPasswordChangeColumn(db *sqlx.DB) error {
+	exists, err := columnExists(db, "Users", "last_password_change_at")
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(`ALTER TABLE Users ADD COLUMN last_password_change_at TEXT`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`UPDATE Users SET last_password_change_at = datetime('now') WHERE last_password_change_at IS NULL`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DBPath returns the path of the database opened by InitDB.
+func DBPath() string {
+	return dbPath
+}
+
+// Ping checks that the database opened by InitDB can still be reached.
+func Ping(ctx context.Context) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	return db.PingContext(ctx)
+}
+
+// DB returns the connection opened by InitDB, for use with NewSQLiteMealRepository.
+func DB() *sqlx.DB {
+	return db
+}
+
+// DefaultDBPath resolves the database location following the XDG Base Directory
+// specification: $XDG_DATA_HOME, then $HOME/.local/share. On Windows %APPDATA%
+// is used instead. If no base directory can be found the current working
+// directory is used as a last resort.
+func DefaultDBPath() (string, error) {
+	var base string
+
+	if runtime.GOOS == "windows" {
+		base = os.Getenv("APPDATA")
+	} else if xdg := os.Getenv("XDG_DATA_HOME"); xdg != "" {
+		base = xdg
+	} else if home, err := os.UserHomeDir(); err == nil {
+		base = filepath.Join(home, ".local", "share")
+	}
+
+	if base == "" {
+		return DbName, nil
+	}
+
+	dir := filepath.Join(base, AppDirName)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, DbFileName), nil
+}
+
+// MigrateAddNutritionColumns adds the nullable calories, protein, carbs and fat
+// columns to Meals tables created before nutrition was tracked.
+func MigrateAddNutritionColumns(db *sqlx.DB) error {
+	for _, column := range []string{"calories", "protein", "carbs", "fat"} {
+		exists, err := columnExists(db, "Meals", column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		_, err = db.Exec(`ALTER TABLE Meals ADD COLUMN ` + column + ` REAL`)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateAddQuantityColumns adds the portion size columns to databases created
+// before they existed.
+func MigrateAddQuantityColumns(db *sqlx.DB) error {
+	columns := map[string]string{
+		"quantity": "REAL NOT NULL DEFAULT 0",
+		"unit":     "TEXT NOT NULL DEFAULT ''",
+	}
+
+	for column, definition := range columns {
+		exists, err := columnExists(db, "Meals", column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		_, err = db.Exec(`ALTER TABLE Meals ADD COLUMN ` + column + ` ` + definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateAddNotesColumn adds the notes column to databases created before it
+// existed. Existing meals get empty notes rather than NULL so they still scan
+// into Meal.
+func MigrateAddNotesColumn(db *sqlx.DB) error {
+	exists, err := columnExists(db, "Meals", "notes")
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	_, err = db.Exec(`ALTER TABLE Meals ADD COLUMN notes TEXT DEFAULT ''`)
+	return err
+}
+
+// MigrateAddAuditColumns adds created_at and updated_at to databases created
+// before they existed. SQLite does not allow a CURRENT_TIMESTAMP default on an
+// added column, so existing meals are backfilled from date_consumed instead,
+// converted from local time to UTC.
+func MigrateAddAuditColumns(db *sqlx.DB) error {
+	for _, column := range []string{"created_at", "updated_at"} {
+		exists, err := columnExists(db, "Meals", column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		_, err = db.Exec(`ALTER TABLE Meals ADD COLUMN ` + column + ` TEXT NOT NULL DEFAULT ''`)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(`UPDATE Meals SET ` + column + ` = COALESCE(datetime(date_consumed, 'utc'), datetime('now')) WHERE ` + column + ` = ''`)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateAddPhotoPathColumn adds the photo_path column to databases created
+// before meal photos could be uploaded.
+func MigrateAddPhotoPathColumn(db *sqlx.DB) error {
+	exists, err := columnExists(db, "Meals", "photo_path")
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	_, err = db.Exec(`ALTER TABLE Meals ADD COLUMN photo_path TEXT NOT NULL DEFAULT ''`)
+	return err
+}
@@ -0,0 +1,130 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestWithTransaction(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name      string
+		fnErr     error
+		wantUsers int
+	}{
+		{"commits when fn succeeds", nil, 1},
+		{"rolls back when fn fails", errFailed, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetDB(t)
+
+			err := WithTransaction(context.Background(), func(tx *sqlx.Tx) error {
+				_, err := tx.Exec(`INSERT INTO Users (email, password) VALUES ('a@b.com', 'hash')`)
+				if err != nil {
+					return err
+				}
+				return tt.fnErr
+			})
+			if !errors.Is(err, tt.fnErr) {
+				t.Fatalf("WithTransaction returned %v, want %v", err, tt.fnErr)
+			}
+
+			count, err := GetUserCount(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.wantUsers {
+				t.Errorf("%d users stored, want %d", count, tt.wantUsers)
+			}
+		})
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	resetDB(t)
+	user := insertTestUser(t, "a@b.com")
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+
+	for i := 0; i < 100; i++ {
+		meal := insertTestMeal(t, user, "meal "+strconv.Itoa(i), Lunch, at)
+		if err := DeleteMealByUserAndId(context.Background(), user, strconv.FormatInt(meal.Id, 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Vacuum(); err != nil {
+		t.Fatalf("Vacuum: %v", err)
+	}
+
+	count, err := GetMealCountByUser(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d meals left after deleting them all", count)
+	}
+}
+
+func TestDropAndRecreateSchemaOutsideTests(t *testing.T) {
+	// t.Setenv puts GO_ENV=test back for the tests that follow
+	t.Setenv("GO_ENV", "prod")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("DropAndRecreateSchema did not panic with GO_ENV=prod")
+		}
+	}()
+	DropAndRecreateSchema()
+}
+
+func TestValidateDBPath(t *testing.T) {
+	dir := t.TempDir()
+
+	existing := filepath.Join(dir, "existing.db")
+	if err := os.WriteFile(existing, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	readOnly := filepath.Join(dir, "read-only")
+	if err := os.Mkdir(readOnly, 0o555); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+		perms   bool // relies on file permissions, which root bypasses
+	}{
+		{"existing file", existing, false, false},
+		{"new file in writable directory", filepath.Join(dir, "new.db"), false, false},
+		{"directory", dir, true, false},
+		{"missing parent", filepath.Join(dir, "missing", "meals.db"), true, false},
+		{"parent is a file", filepath.Join(existing, "meals.db"), true, false},
+		{"read-only parent", filepath.Join(readOnly, "meals.db"), true, true},
+		{"in memory", ":memory:", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.perms && os.Geteuid() == 0 {
+				t.Skip("running as root")
+			}
+
+			err := ValidateDBPath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateDBPath(%q) = %v, want error %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+//go:build !windows
+
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultDBPath(t *testing.T) {
+	xdg := t.TempDir()
+	home := t.TempDir()
+
+	tests := []struct {
+		name    string
+		xdg     string
+		home    string
+		want    string
+		wantDir string // created by DefaultDBPath
+	}{
+		{"XDG_DATA_HOME", xdg, home, filepath.Join(xdg, AppDirName, DbFileName), filepath.Join(xdg, AppDirName)},
+		{"HOME", "", home, filepath.Join(home, ".local", "share", AppDirName, DbFileName), filepath.Join(home, ".local", "share", AppDirName)},
+		{"neither", "", "", DbName, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_DATA_HOME", tt.xdg)
+			t.Setenv("HOME", tt.home)
+
+			got, err := DefaultDBPath()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("DefaultDBPath() = %q, want %q", got, tt.want)
+			}
+
+			if tt.wantDir != "" {
+				if info, err := os.Stat(tt.wantDir); err != nil || !info.IsDir() {
+					t.Errorf("%s was not created: %v", tt.wantDir, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package repo
+
+import (
+	"strings"
+)
+
+// FoodItem is a food with reference nutrition values, used to suggest meal
+// names as the user types.
+type FoodItem struct {
+	Name            string  `json:"name"`
+	CaloriesPer100g float64 `json:"calories_per_100g"`
+	ServingSize     float64 `json:"serving_size"` // grams in a typical serving
+}
+
+// FoodLookup searches a food database by name. It is the integration point for
+// an external source such as Open Food Facts or the USDA FoodData Central API.
+type FoodLookup interface {
+	SearchFoods(query string) ([]FoodItem, error)
+}
+
+// StaticFoodLookup searches a small built in list of common foods, so that
+// suggestions work without an external food database.
+type StaticFoodLookup struct{}
+
+var staticFoods = []FoodItem{
+	{Name: "Apple", CaloriesPer100g: 52, ServingSize: 182},
+	{Name: "Avocado", CaloriesPer100g: 160, ServingSize: 150},
+	{Name: "Bagel", CaloriesPer100g: 250, ServingSize: 105},
+	{Name: "Banana", CaloriesPer100g: 89, ServingSize: 118},
+	{Name: "Broccoli", CaloriesPer100g: 34, ServingSize: 91},
+	{Name: "Brown rice", CaloriesPer100g: 112, ServingSize: 195},
+	{Name: "Cheddar cheese", CaloriesPer100g: 403, ServingSize: 28},
+	{Name: "Chicken breast", CaloriesPer100g: 165, ServingSize: 120},
+	{Name: "Egg", CaloriesPer100g: 155, ServingSize: 50},
+	{Name: "Greek yoghurt", CaloriesPer100g: 59, ServingSize: 170},
+	{Name: "Milk", CaloriesPer100g: 42, ServingSize: 244},
+	{Name: "Oats", CaloriesPer100g: 389, ServingSize: 40},
+	{Name: "Orange", CaloriesPer100g: 47, ServingSize: 131},
+	{Name: "Pasta", CaloriesPer100g: 131, ServingSize: 140},
+	{Name: "Peanut butter", CaloriesPer100g: 588, ServingSize: 32},
+	{Name: "Potato", CaloriesPer100g: 77, ServingSize: 173},
+	{Name: "Salmon", CaloriesPer100g: 208, ServingSize: 154},
+	{Name: "Spinach", CaloriesPer100g: 23, ServingSize: 30},
+	{Name: "White bread", CaloriesPer100g: 265, ServingSize: 25},
+	{Name: "White rice", CaloriesPer100g: 130, ServingSize: 158},
+}
+
+// SearchFoods returns the foods whose name contains query, ignoring case.
+func (StaticFoodLookup) SearchFoods(query string) ([]FoodItem, error) {
+	query = strings.ToLower(query)
+
+	foods := []FoodItem{}
+	for _, food := range staticFoods {
+		if strings.Contains(strings.ToLower(food.Name), query) {
+			foods = append(foods, food)
+		}
+	}
+
+	return foods, nil
+}
@@ -1,18 +1,43 @@
 package repo
 
 import (
+	"context"
+	"slices"
 	"time"
 
-	_ "github.com/jmoiron/sqlx"
 	_ "modernc.org/sqlite"
 )
 
 type Meal struct {
-	Id           int64  `db:"id"`
-	UserID       int64  `db:"user_id"`
-	Name         string `db:"name"`
-	MealType     string `db:"meal_type"`
-	DateConsumed string `db:"date_consumed"`
+	Id           int64  `db:"id" json:"id"`
+	UserID       int64  `db:"user_id" json:"user_id"`
+	Name         string `db:"name" json:"name"`
+	MealType     string `db:"meal_type" json:"meal_type"`
+	DateConsumed string `db:"date_consumed" json:"date_consumed"`
+
+	// optional portion size, e.g. 2 "serving" or 150 "g". Quantity is 0 and
+	// Unit empty when not recorded.
+	Quantity float64 `db:"quantity" json:"quantity"`
+	Unit     string  `db:"unit" json:"unit"`
+
+	Notes string `db:"notes" json:"notes"` // free-form, e.g. where it was eaten
+
+	// URL of the uploaded photo under /uploads, empty when there is none
+	PhotoPath string `db:"photo_path" json:"photo_path"`
+
+	// tag names, only filled in by LoadTags and GetMealsByTag
+	Tags []string `db:"-" json:"tags,omitempty"`
+
+	// optional nutrition information, nil when not recorded
+	Calories *float64 `db:"calories" json:"calories"`
+	Protein  *float64 `db:"protein" json:"protein"`
+	Carbs    *float64 `db:"carbs" json:"carbs"`
+	Fat      *float64 `db:"fat" json:"fat"`
+
+	// when the row was stored and last changed, as opposed to when the meal
+	// was eaten
+	CreatedAt AuditTime `db:"created_at" json:"created_at"`
+	UpdatedAt AuditTime `db:"updated_at" json:"updated_at"`
 }
 
 var MealsSchema = `CREATE TABLE IF NOT EXISTS Meals (
@@ -20,13 +45,37 @@ var MealsSchema = `CREATE TABLE IF NOT EXISTS Meals (
 	user_id INTEGER REFERENCES Users(id),
 	name TEXT NOT NULL,
 	meal_type TEXT NOT NULL,
-	date_consumed TEXT NOT NULL
+	date_consumed TEXT NOT NULL,
+	calories REAL,
+	protein REAL,
+	carbs REAL,
+	fat REAL,
+	quantity REAL NOT NULL DEFAULT 0,
+	unit TEXT NOT NULL DEFAULT '',
+	notes TEXT DEFAULT '',
+	photo_path TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
 )`
 
+// insertMealQuery sets the audit columns from the meal, see stampInsert
+const insertMealQuery = `INSERT INTO Meals(name, user_id, meal_type, date_consumed, calories, protein, carbs, fat, quantity, unit, notes, created_at, updated_at)
+	VALUES (:name, :user_id, :meal_type, :date_consumed, :calories, :protein, :carbs, :fat, :quantity, :unit, :notes, :created_at, :updated_at)`
+
+// stampInsert sets both audit timestamps to now. The columns default to
+// CURRENT_TIMESTAMP, but setting them here means the returned meal matches
+// what was stored without reading it back.
+func stampInsert(meal *Meal) {
+	now := NewAuditTime(time.Now())
+	meal.CreatedAt = now
+	meal.UpdatedAt = now
+}
+
 type MealType string
 
 const (
-	Timestamp = "2006-01-02 15:04:05"
+	Timestamp  = "2006-01-02 15:04:05"
+	DateFormat = "2006-01-02"
 
 	Breakfast MealType = "breakfast"
 	Lunch     MealType = "lunch"
@@ -34,70 +83,313 @@ const (
 	Snacks    MealType = "snacks"
 )
 
-func NewMeal(name string, userId int64, mealType MealType, time time.Time) Meal {
-	return Meal{
+// MealTypes lists every meal type in the order they are eaten through the day.
+var MealTypes = []MealType{
+	Breakfast,
+	Lunch,
+	Dinner,
+	Snacks,
+}
+
+// IsValidMealType reports whether t is one of MealTypes.
+func IsValidMealType(t MealType) bool {
+	return slices.Contains(MealTypes, t)
+}
+
+// DateConsumedTime parses DateConsumed, which is stored as local time in the
+// Timestamp format.
+func (m Meal) DateConsumedTime() (time.Time, error) {
+	return time.ParseInLocation(Timestamp, m.DateConsumed, time.Local)
+}
+
+// MealOption sets an optional field when building a meal with NewMeal.
+type MealOption func(*Meal)
+
+// WithQuantity records the portion size, e.g. WithQuantity(2, "serving").
+func WithQuantity(q float64, unit string) MealOption {
+	return func(m *Meal) {
+		m.Quantity = q
+		m.Unit = unit
+	}
+}
+
+func NewMeal(name string, userId int64, mealType MealType, time time.Time, opts ...MealOption) Meal {
+	meal := Meal{
 		Name:         name,
 		UserID:       userId,
 		MealType:     string(mealType),
 		DateConsumed: time.Format(Timestamp),
 	}
-}
 
-func InsertMeal(meal Meal) (Meal, error) {
-	query := `INSERT INTO Meals(name, user_id, meal_type, date_consumed) VALUES (:name, :user_id, :meal_type, :date_consumed)`
-
-	res, err := db.NamedExec(query, meal)
-	if err != nil {
-		return meal, err
+	for _, opt := range opts {
+		opt(&meal)
 	}
 
-	lastInsertID, err := res.LastInsertId()
-	if err != nil {
-		return meal, err
+	return meal
+}
+
+// WithNotes attaches free-form notes to the meal.
+func WithNotes(notes string) MealOption {
+	return func(m *Meal) {
+		m.Notes = notes
 	}
+}
 
-	meal.Id = lastInsertID
+// NewMealWithNutrition is NewMeal with nutrition values, any of which may be nil.
+func NewMealWithNutrition(name string, userId int64, mealType MealType, time time.Time, calories, protein, carbs, fat *float64, opts ...MealOption) Meal {
+	meal := NewMeal(name, userId, mealType, time, opts...)
+	meal.Calories = calories
+	meal.Protein = protein
+	meal.Carbs = carbs
+	meal.Fat = fat
 
-	return meal, nil
+	return meal
 }
 
-func GetAllMeals() ([]Meal, error) {
-	query := `SELECT * FROM Meals`
+func InsertMeal(ctx context.Context, meal Meal) (Meal, error) {
+	return SQLiteMealRepository{DB: db}.InsertMeal(ctx, meal)
+}
 
-	var meals []Meal
-	err := db.Select(&meals, query)
-	if err != nil {
-		return meals, err
-	}
+// BulkInsertMeals inserts all meals in a single transaction, so either every
+// meal is stored or none are. The insert is prepared once and reused for
+// every meal. Tags are created as needed, as with InsertMeal.
+func BulkInsertMeals(ctx context.Context, meals []Meal) ([]Meal, error) {
+	return SQLiteMealRepository{DB: db}.BulkInsertMeals(ctx, meals)
+}
 
-	return meals, nil
+// GetAllMeals returns every user's meals and is only meant for admin use.
+// User facing code must use GetAllMealsByUser.
+func GetAllMeals(ctx context.Context) ([]Meal, error) {
+	return SQLiteMealRepository{DB: db}.GetAllMeals(ctx)
 }
 
-func GetMealsByUserAndDate(user User, inTime time.Time) ([]Meal, error) {
-	query := `SELECT * FROM Meals WHERE user_id = ? AND DATE(date_consumed) = DATE(?)`
+func GetAllMealsByUser(ctx context.Context, user User) ([]Meal, error) {
+	return SQLiteMealRepository{DB: db}.GetAllMealsByUser(ctx, user)
+}
 
-	var meals []Meal
+// GetMealsByUserPaginated returns one page of the user's meals, most recent
+// first, along with the total number of meals the user has.
+func GetMealsByUserPaginated(ctx context.Context, user User, limit, offset int) ([]Meal, int, error) {
+	return SQLiteMealRepository{DB: db}.GetMealsByUserPaginated(ctx, user, limit, offset)
+}
 
-	err := db.Select(&meals, query, user.Id, inTime.Format("2006-01-02"))
-	if err != nil {
-		return meals, err
-	}
+// SearchMealsByName returns up to limit of the user's meals whose name
+// contains query, ignoring case for ASCII letters, most recent first.
+func SearchMealsByName(ctx context.Context, user User, query string, limit int) ([]Meal, error) {
+	return SQLiteMealRepository{DB: db}.SearchMealsByName(ctx, user, query, limit)
+}
 
-	return meals, nil
+func GetMealByIdAndUser(ctx context.Context, id int64, user User) (Meal, error) {
+	return SQLiteMealRepository{DB: db}.GetMealByIdAndUser(ctx, id, user)
 }
 
-func DeleteMealByUserAndId(user User, id string) error {
-	query := `DELETE FROM Meals WHERE user_id = ? AND id = ?`
+func GetMealsByUserAndDate(ctx context.Context, user User, inTime time.Time) ([]Meal, error) {
+	return SQLiteMealRepository{DB: db}.GetMealsByUserAndDate(ctx, user, inTime)
+}
 
-	res, err := db.Exec(query, user.Id, id)
-	if err != nil {
-		return err
-	}
+func GetMealsByUserAndDateSortedByType(ctx context.Context, user User, date time.Time) ([]Meal, error) {
+	return SQLiteMealRepository{DB: db}.GetMealsByUserAndDateSortedByType(ctx, user, date)
+}
 
-	_, err = res.LastInsertId()
-	if err != nil {
-		return err
-	}
+// GetMealsByUserAndDateRange returns the user's meals eaten between from and
+// to, both days inclusive.
+func GetMealsByUserAndDateRange(ctx context.Context, user User, from, to time.Time) ([]Meal, error) {
+	return SQLiteMealRepository{DB: db}.GetMealsByUserAndDateRange(ctx, user, from, to)
+}
+
+// GetMealsByUserAndWeek returns the user's meals from the seven days starting
+// at weekStart.
+func GetMealsByUserAndWeek(ctx context.Context, user User, weekStart time.Time) ([]Meal, error) {
+	return SQLiteMealRepository{DB: db}.GetMealsByUserAndWeek(ctx, user, weekStart)
+}
+
+// GetWeeklyMealSummary is GetMealsByUserAndWeek grouped by day, keyed by
+// DateFormat. Days without meals are left out.
+func GetWeeklyMealSummary(ctx context.Context, user User, weekStart time.Time) (map[string][]Meal, error) {
+	return SQLiteMealRepository{DB: db}.GetWeeklyMealSummary(ctx, user, weekStart)
+}
+
+// GetMealsByUserAndMonth returns the user's meals eaten in the given month.
+func GetMealsByUserAndMonth(ctx context.Context, user User, year int, month time.Month) ([]Meal, error) {
+	return SQLiteMealRepository{DB: db}.GetMealsByUserAndMonth(ctx, user, year, month)
+}
+
+// GetMonthlyMealSummary groups the month's meals by the date they were eaten,
+// keyed as DateFormat. Days without meals have no entry.
+func GetMonthlyMealSummary(ctx context.Context, user User, year int, month time.Month) (map[string][]Meal, error) {
+	return SQLiteMealRepository{DB: db}.GetMonthlyMealSummary(ctx, user, year, month)
+}
+
+func GetMealsByUserAndType(ctx context.Context, user User, mealType MealType) ([]Meal, error) {
+	return SQLiteMealRepository{DB: db}.GetMealsByUserAndType(ctx, user, mealType)
+}
+
+func GetMealCountByUser(ctx context.Context, user User) (int, error) {
+	return SQLiteMealRepository{DB: db}.GetMealCountByUser(ctx, user)
+}
+
+// GetDistinctMealDates returns each day the user logged at least one meal,
+// most recent first.
+func GetDistinctMealDates(ctx context.Context, user User) ([]time.Time, error) {
+	return SQLiteMealRepository{DB: db}.GetDistinctMealDates(ctx, user)
+}
+
+// GetTotalMealCount counts the meals of every user.
+func GetTotalMealCount(ctx context.Context) (int, error) {
+	return SQLiteMealRepository{DB: db}.GetTotalMealCount(ctx)
+}
+
+// GetFirstMealDate returns when the user logged their earliest meal, or the
+// zero time when they have no meals yet.
+func GetFirstMealDate(ctx context.Context, user User) (time.Time, error) {
+	return SQLiteMealRepository{DB: db}.GetFirstMealDate(ctx, user)
+}
+
+// GetLastMealDate returns when the user logged their latest meal, or the
+// zero time when they have no meals yet.
+func GetLastMealDate(ctx context.Context, user User) (time.Time, error) {
+	return SQLiteMealRepository{DB: db}.GetLastMealDate(ctx, user)
+}
+
+func GetMealCountByUserAndDate(ctx context.Context, user User, inTime time.Time) (int, error) {
+	return SQLiteMealRepository{DB: db}.GetMealCountByUserAndDate(ctx, user, inTime)
+}
+
+func GetMealCountByUserAndDateRange(ctx context.Context, user User, from, to time.Time) (int, error) {
+	return SQLiteMealRepository{DB: db}.GetMealCountByUserAndDateRange(ctx, user, from, to)
+}
+
+func GetMealCountByType(ctx context.Context, user User, mealType MealType) (int, error) {
+	return SQLiteMealRepository{DB: db}.GetMealCountByType(ctx, user, mealType)
+}
+
+func GetMealCountByTypeAndDateRange(ctx context.Context, user User, mealType MealType, from, to time.Time) (int, error) {
+	return SQLiteMealRepository{DB: db}.GetMealCountByTypeAndDateRange(ctx, user, mealType, from, to)
+}
+
+// GetMealCountByTypeForUser returns how many meals the user logged of each
+// type. Every type in MealTypes is present, with 0 when never logged.
+func GetMealCountByTypeForUser(ctx context.Context, user User) (map[MealType]int, error) {
+	return SQLiteMealRepository{DB: db}.GetMealCountByTypeForUser(ctx, user)
+}
+
+// GetMealStreak returns how many consecutive days, counting back from today,
+// the user has logged at least one meal. It is 0 when nothing is logged today.
+func GetMealStreak(ctx context.Context, user User) (int, error) {
+	return SQLiteMealRepository{DB: db}.GetMealStreak(ctx, user)
+}
+
+// GetMealsByUserAndDayOfWeek returns the user's meals eaten on dow, where
+// 0 is Sunday and 6 is Saturday.
+func GetMealsByUserAndDayOfWeek(ctx context.Context, user User, dow int) ([]Meal, error) {
+	return SQLiteMealRepository{DB: db}.GetMealsByUserAndDayOfWeek(ctx, user, dow)
+}
+
+// GetMealCountsByDayOfWeek returns how many meals the user logged on each
+// weekday, indexed by time.Weekday.
+func GetMealCountsByDayOfWeek(ctx context.Context, user User) ([7]int, error) {
+	return SQLiteMealRepository{DB: db}.GetMealCountsByDayOfWeek(ctx, user)
+}
+
+// GetCalorieAverageByDayOfWeek returns the user's average daily calorie total
+// on each weekday, indexed by time.Weekday. Meals without calories are left
+// out, and a weekday with no calories logged averages 0.
+func GetCalorieAverageByDayOfWeek(ctx context.Context, user User) ([7]float64, error) {
+	return SQLiteMealRepository{DB: db}.GetCalorieAverageByDayOfWeek(ctx, user)
+}
+
+// DailySummaryRow aggregates a single day of a user's meals.
+type DailySummaryRow struct {
+	Date          string  `db:"date" json:"date"`
+	MealCount     int     `db:"meal_count" json:"meal_count"`
+	TotalCalories int     `db:"total_calories" json:"total_calories"`
+	AvgRating     float64 `db:"avg_rating" json:"avg_rating"`
+}
+
+// GetDailySummaryRows returns up to limit days of aggregates, most recent first.
+// Meals without calories count as 0. Meals do not record a rating yet, so
+// AvgRating is always 0.
+func GetDailySummaryRows(ctx context.Context, user User, limit int) ([]DailySummaryRow, error) {
+	return SQLiteMealRepository{DB: db}.GetDailySummaryRows(ctx, user, limit)
+}
+
+// FrequentMeal is a meal name and how many times it was logged.
+type FrequentMeal struct {
+	Name     string   `db:"name" json:"name"`
+	Count    int      `db:"count" json:"count"`
+	MealType MealType `db:"meal_type" json:"meal_type"` // the type the meal is most often logged as
+}
+
+// GetMostFrequentMeals returns the user's limit most logged meal names, most
+// common first.
+func GetMostFrequentMeals(ctx context.Context, user User, limit int) ([]FrequentMeal, error) {
+	return SQLiteMealRepository{DB: db}.GetMostFrequentMeals(ctx, user, limit)
+}
+
+// DailyCalories is the calorie total for one day.
+type DailyCalories struct {
+	Date  string  `db:"date" json:"date"`
+	Total float64 `db:"total" json:"total"`
+}
+
+// GetDailyCalorieTotals returns a total for each day between from and to,
+// inclusive, on which the user logged a meal, oldest first. Meals without
+// calories count as 0.
+func GetDailyCalorieTotals(ctx context.Context, user User, from, to time.Time) ([]DailyCalories, error) {
+	return SQLiteMealRepository{DB: db}.GetDailyCalorieTotals(ctx, user, from, to)
+}
+
+// DailyCount is how many meals were logged on a day.
+type DailyCount struct {
+	Date  string `db:"date" json:"date"`
+	Count int    `db:"count" json:"count"`
+}
+
+// GetMealsPerDay returns a count for every day between from and to, inclusive,
+// oldest first. Days without meals are filled in with a count of 0 so that the
+// series has no gaps.
+func GetMealsPerDay(ctx context.Context, user User, from, to time.Time) ([]DailyCount, error) {
+	return SQLiteMealRepository{DB: db}.GetMealsPerDay(ctx, user, from, to)
+}
+
+// UpdateMeal saves the meal's name, type, nutrition and notes, provided it belongs to
+// user, and returns the stored row. sql.ErrNoRows is returned when no meal matched.
+func UpdateMeal(ctx context.Context, user User, meal Meal) (Meal, error) {
+	return SQLiteMealRepository{DB: db}.UpdateMeal(ctx, user, meal)
+}
+
+func DeleteMealByUserAndId(ctx context.Context, user User, id string) error {
+	return SQLiteMealRepository{DB: db}.DeleteMealByUserAndId(ctx, user, id)
+}
+
+// CopyMealsToDate logs every meal the user ate on fromDate again on toDate,
+// keeping each meal's time of day and tags. Meals already on toDate are kept.
+// It returns how many meals were copied.
+func CopyMealsToDate(ctx context.Context, user User, fromDate, toDate time.Time) (int, error) {
+	return SQLiteMealRepository{DB: db}.CopyMealsToDate(ctx, user, fromDate, toDate)
+}
+
+// SetMealPhoto records where the photo of one of the user's meals is served
+// from. sql.ErrNoRows is returned when the user has no such meal.
+func SetMealPhoto(ctx context.Context, mealId int64, userId int64, path string) error {
+	return SQLiteMealRepository{DB: db}.SetMealPhoto(ctx, mealId, userId, path)
+}
+
+// DuplicateMeal logs one of the user's meals, with its tags, again at newTime.
+// The photo is not copied. sql.ErrNoRows is returned when the user has no
+// such meal.
+func DuplicateMeal(ctx context.Context, mealId int64, userId int64, newTime time.Time) (Meal, error) {
+	return SQLiteMealRepository{DB: db}.DuplicateMeal(ctx, mealId, userId, newTime)
+}
+
+// DeleteMealsByUserBefore removes every meal the user ate before the given
+// day and returns how many were deleted.
+func DeleteMealsByUserBefore(ctx context.Context, user User, before time.Time) (int64, error) {
+	return SQLiteMealRepository{DB: db}.DeleteMealsByUserBefore(ctx, user, before)
+}
 
-	return nil
+// DeleteMealsByUserAndDate removes every meal the user logged on date and
+// returns how many were deleted.
+func DeleteMealsByUserAndDate(ctx context.Context, user User, date time.Time) (int64, error) {
+	return SQLiteMealRepository{DB: db}.DeleteMealsByUserAndDate(ctx, user, date)
 }
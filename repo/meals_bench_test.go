@@ -0,0 +1,45 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// benchMealCount is how many meals each iteration of the insert benchmarks stores.
+const benchMealCount = 100
+
+func benchMeals(user User) []Meal {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	meals := make([]Meal, benchMealCount)
+	for i := range meals {
+		meals[i] = NewMeal("soup", user.Id, Lunch, at.Add(time.Duration(i)*time.Minute))
+	}
+	return meals
+}
+
+func BenchmarkInsertMealLoop(b *testing.B) {
+	resetDB(b)
+	meals := benchMeals(insertTestUser(b, "a@b.com"))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, meal := range meals {
+			if _, err := InsertMeal(context.Background(), meal); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkBulkInsertMeals(b *testing.B) {
+	resetDB(b)
+	meals := benchMeals(insertTestUser(b, "a@b.com"))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := BulkInsertMeals(context.Background(), meals); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
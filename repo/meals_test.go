@@ -0,0 +1,406 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+func TestMain(m *testing.M) {
+	// DropAndRecreateSchema refuses to run anywhere else
+	os.Setenv("GO_ENV", "test")
+
+	if err := InitDB(":memory:"); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// resetDB empties every table so each test starts from a fresh schema.
+func resetDB(t testing.TB) {
+	t.Helper()
+	if err := DropAndRecreateSchema(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func insertTestUser(t testing.TB, email string) User {
+	t.Helper()
+	user, err := InsertUser(context.Background(), NewUser(email, "not-a-real-hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func insertTestMeal(t testing.TB, user User, name string, mealType MealType, at time.Time) Meal {
+	t.Helper()
+	meal, err := InsertMeal(context.Background(), NewMeal(name, user.Id, mealType, at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meal
+}
+
+func TestIsValidMealType(t *testing.T) {
+	tests := []struct {
+		mealType MealType
+		want     bool
+	}{
+		{Breakfast, true},
+		{Lunch, true},
+		{Dinner, true},
+		{Snacks, true},
+		{"", false},
+		{"garbage", false},
+		{"Breakfast", false},
+		{"snack", false},
+		{" lunch", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsValidMealType(tt.mealType); got != tt.want {
+			t.Errorf("IsValidMealType(%q) = %v, want %v", tt.mealType, got, tt.want)
+		}
+	}
+}
+
+func TestInsertMeal(t *testing.T) {
+	resetDB(t)
+	user := insertTestUser(t, "a@b.com")
+	at := time.Date(2024, 3, 1, 8, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		name string
+		meal Meal
+	}{
+		{"plain", NewMeal("toast", user.Id, Breakfast, at)},
+		{"with notes", NewMeal("soup", user.Id, Lunch, at, WithNotes("too salty"))},
+		{"with quantity", NewMeal("rice", user.Id, Dinner, at, WithQuantity(200, "g"))},
+		{"with tags", NewMeal("apple", user.Id, Snacks, at, WithTags("fruit", "home"))},
+	}
+
+	seen := make(map[int64]bool)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meal, err := InsertMeal(context.Background(), tt.meal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meal.Id == 0 {
+				t.Fatal("InsertMeal returned a zero Id")
+			}
+			if seen[meal.Id] {
+				t.Errorf("Id %d was returned twice", meal.Id)
+			}
+			seen[meal.Id] = true
+
+			stored, err := GetMealByIdAndUser(context.Background(), meal.Id, user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Name != tt.meal.Name {
+				t.Errorf("stored name = %q, want %q", stored.Name, tt.meal.Name)
+			}
+		})
+	}
+}
+
+func TestGetMealsByUserAndDate(t *testing.T) {
+	resetDB(t)
+	user := insertTestUser(t, "a@b.com")
+	other := insertTestUser(t, "c@d.com")
+
+	day := func(d, hour int) time.Time {
+		return time.Date(2024, 3, d, hour, 0, 0, 0, time.Local)
+	}
+	insertTestMeal(t, user, "late dinner", Dinner, day(1, 23))
+	insertTestMeal(t, user, "toast", Breakfast, day(2, 0))
+	insertTestMeal(t, user, "soup", Lunch, day(2, 12))
+	insertTestMeal(t, user, "cake", Snacks, day(3, 15))
+	insertTestMeal(t, other, "not mine", Lunch, day(2, 12))
+
+	tests := []struct {
+		name string
+		date time.Time
+		want []string
+	}{
+		{"first day", day(1, 9), []string{"late dinner"}},
+		{"from midnight", day(2, 9), []string{"toast", "soup"}},
+		{"time of day is ignored", day(3, 0), []string{"cake"}},
+		{"no meals", day(4, 9), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meals, err := GetMealsByUserAndDate(context.Background(), user, tt.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, meal := range meals {
+				got = append(got, meal.Name)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteMealByUserAndId(t *testing.T) {
+	resetDB(t)
+	owner := insertTestUser(t, "a@b.com")
+	other := insertTestUser(t, "c@d.com")
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name     string
+		deleter  User
+		wantGone bool
+	}{
+		{"owner", owner, true},
+		{"another user", other, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meal := insertTestMeal(t, owner, "soup", Lunch, at)
+
+			err := DeleteMealByUserAndId(context.Background(), tt.deleter, strconv.FormatInt(meal.Id, 10))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = GetMealByIdAndUser(context.Background(), meal.Id, owner)
+			gone := errors.Is(err, sql.ErrNoRows)
+			if err != nil && !gone {
+				t.Fatal(err)
+			}
+			if gone != tt.wantGone {
+				t.Errorf("meal gone = %v, want %v", gone, tt.wantGone)
+			}
+		})
+	}
+}
+
+func TestGetMealsPerDay(t *testing.T) {
+	resetDB(t)
+	user := insertTestUser(t, "a@b.com")
+	other := insertTestUser(t, "c@d.com")
+
+	day := func(d, hour int) time.Time {
+		return time.Date(2024, 3, d, hour, 0, 0, 0, time.Local)
+	}
+	// meals on the 2nd and 5th only, with gaps either side and between
+	insertTestMeal(t, user, "toast", Breakfast, day(2, 8))
+	insertTestMeal(t, user, "soup", Lunch, day(2, 12))
+	insertTestMeal(t, user, "cake", Snacks, day(5, 15))
+	insertTestMeal(t, user, "outside the range", Dinner, day(7, 19))
+	insertTestMeal(t, other, "not mine", Lunch, day(3, 12))
+
+	got, err := GetMealsPerDay(context.Background(), user, day(1, 0), day(6, 23))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []DailyCount{
+		{Date: "2024-03-01", Count: 0},
+		{Date: "2024-03-02", Count: 2},
+		{Date: "2024-03-03", Count: 0},
+		{Date: "2024-03-04", Count: 0},
+		{Date: "2024-03-05", Count: 1},
+		{Date: "2024-03-06", Count: 0},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d days %v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("day %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestQueryWithExpiredContext(t *testing.T) {
+	resetDB(t)
+	user := insertTestUser(t, "a@b.com")
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-1*time.Second))
+	defer cancel()
+
+	_, err := GetMealsByUserAndDate(ctx, user, time.Now())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestGetMealsByUserAndDayOfWeek(t *testing.T) {
+	resetDB(t)
+	user := insertTestUser(t, "a@b.com")
+	other := insertTestUser(t, "c@d.com")
+
+	// 3 March 2024 is a Sunday
+	at := func(d, hour, min, sec int) time.Time {
+		return time.Date(2024, 3, d, hour, min, sec, 0, time.Local)
+	}
+	insertTestMeal(t, user, "saturday supper", Dinner, at(2, 23, 59, 59))
+	insertTestMeal(t, user, "sunday breakfast", Breakfast, at(3, 0, 0, 0))
+	insertTestMeal(t, user, "sunday snack", Snacks, at(3, 23, 59, 59))
+	insertTestMeal(t, user, "monday breakfast", Breakfast, at(4, 0, 0, 0))
+	insertTestMeal(t, user, "next sunday", Lunch, at(10, 12, 0, 0))
+	insertTestMeal(t, other, "not mine", Lunch, at(3, 12, 0, 0))
+
+	tests := []struct {
+		dow  time.Weekday
+		want []string
+	}{
+		{time.Sunday, []string{"sunday breakfast", "sunday snack", "next sunday"}},
+		{time.Monday, []string{"monday breakfast"}},
+		{time.Tuesday, nil},
+		{time.Saturday, []string{"saturday supper"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dow.String(), func(t *testing.T) {
+			meals, err := GetMealsByUserAndDayOfWeek(context.Background(), user, int(tt.dow))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]bool, len(meals))
+			for _, meal := range meals {
+				got[meal.Name] = true
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d meals %v, want %v", len(meals), got, tt.want)
+			}
+			for _, name := range tt.want {
+				if !got[name] {
+					t.Errorf("missing %q", name)
+				}
+			}
+		})
+	}
+}
+
+func TestGetCalorieAverageByDayOfWeek(t *testing.T) {
+	resetDB(t)
+	user := insertTestUser(t, "a@b.com")
+	other := insertTestUser(t, "c@d.com")
+
+	insert := func(user User, name string, at time.Time, calories *float64) {
+		t.Helper()
+		meal := NewMealWithNutrition(name, user.Id, Lunch, at, calories, nil, nil, nil)
+		if _, err := InsertMeal(context.Background(), meal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kcal := func(v float64) *float64 { return &v }
+	at := func(d, hour int) time.Time {
+		return time.Date(2024, 3, d, hour, 0, 0, 0, time.Local)
+	}
+
+	// two Sundays totalling 1000 and 2000, the second over two meals
+	insert(user, "sunday", at(3, 12), kcal(1000))
+	insert(user, "next sunday lunch", at(10, 12), kcal(1500))
+	insert(user, "next sunday dinner", at(10, 19), kcal(500))
+	// meals without calories neither add to nor dilute the average
+	insert(user, "untracked sunday", at(17, 12), nil)
+	insert(user, "untracked snack", at(10, 15), nil)
+	// a Monday just after midnight
+	insert(user, "monday", time.Date(2024, 3, 4, 0, 0, 0, 0, time.Local), kcal(300))
+	insert(other, "not mine", at(3, 12), kcal(9000))
+
+	got, err := GetCalorieAverageByDayOfWeek(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [7]float64{time.Sunday: 1500, time.Monday: 300}
+	if got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGetMostFrequentMeals(t *testing.T) {
+	resetDB(t)
+	user := insertTestUser(t, "a@b.com")
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+
+	insertTestMeal(t, user, "soup", Lunch, at)
+	insertTestMeal(t, user, "soup", Lunch, at.AddDate(0, 0, 1))
+	insertTestMeal(t, user, "soup", Dinner, at.AddDate(0, 0, 2))
+	insertTestMeal(t, user, "toast", Breakfast, at)
+	insertTestMeal(t, user, "toast", Snacks, at.AddDate(0, 0, 1))
+
+	got, err := GetMostFrequentMeals(context.Background(), user, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// toast is a tie between breakfast and snacks, so its latest type wins
+	want := []FrequentMeal{
+		{Name: "soup", Count: 3, MealType: Lunch},
+		{Name: "toast", Count: 2, MealType: Snacks},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("meal %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestBulkInsertMealsRollsBack(t *testing.T) {
+	resetDB(t)
+	user := insertTestUser(t, "a@b.com")
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+
+	// the last meal belongs to a user that does not exist, which the foreign key rejects
+	meals := []Meal{
+		NewMeal("porridge", user.Id, Breakfast, at),
+		NewMeal("soup", user.Id, Lunch, at),
+		NewMeal("curry", user.Id+1000, Dinner, at),
+	}
+	if _, err := BulkInsertMeals(context.Background(), meals); err == nil {
+		t.Fatal("BulkInsertMeals accepted a meal for a missing user")
+	}
+
+	count, err := GetMealCountByUser(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d meals stored from a failed batch, want 0", count)
+	}
+}
+
+func TestInsertMealMissingUser(t *testing.T) {
+	resetDB(t)
+
+	_, err := InsertMeal(context.Background(), NewMeal("soup", 9999, Lunch, time.Now()))
+
+	var liteErr *sqlite.Error
+	if !errors.As(err, &liteErr) {
+		t.Fatalf("err = %v, want a *sqlite.Error", err)
+	}
+	if code := liteErr.Code(); code != 787 {
+		t.Errorf("code = %d, want 787 (SQLITE_CONSTRAINT_FOREIGNKEY)", code)
+	}
+}
@@ -0,0 +1,135 @@
+package repo
+
+import (
+	"context"
+	"database/sql/driver"
+	"log/slog"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// loggingConnector opens SQLite connections that log every statement, with its
+// number of arguments and duration, at debug level. The argument values are
+// left out as they include password hashes and users' emails.
+type loggingConnector struct {
+	dsn    string
+	driver *sqlite.Driver
+	logger *slog.Logger
+}
+
+func (c loggingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return loggingConn{Conn: conn, logger: c.logger}, nil
+}
+
+func (c loggingConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// loggingConn forwards to the SQLite connection. The optional driver
+// interfaces are implemented explicitly, as embedding driver.Conn would hide
+// them from database/sql.
+type loggingConn struct {
+	driver.Conn
+	logger *slog.Logger
+}
+
+func (c loggingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	res, err := execer.ExecContext(ctx, query, args)
+	logQuery(ctx, c.logger, query, args, start, err)
+	return res, err
+}
+
+func (c loggingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	logQuery(ctx, c.logger, query, args, start, err)
+	return rows, err
+}
+
+func (c loggingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return loggingStmt{Stmt: stmt, query: query, logger: c.logger}, nil
+}
+
+func (c loggingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+
+	return c.Conn.Begin()
+}
+
+func (c loggingConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+// loggingStmt logs each execution of a prepared statement.
+type loggingStmt struct {
+	driver.Stmt
+	query  string
+	logger *slog.Logger
+}
+
+func (s loggingStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	res, err := execer.ExecContext(ctx, args)
+	logQuery(ctx, s.logger, s.query, args, start, err)
+	return res, err
+}
+
+func (s loggingStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, args)
+	logQuery(ctx, s.logger, s.query, args, start, err)
+	return rows, err
+}
+
+func logQuery(ctx context.Context, logger *slog.Logger, query string, args []driver.NamedValue, start time.Time, err error) {
+	attrs := []any{"query", query, "args", len(args), "duration", time.Since(start)}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+
+	logger.DebugContext(ctx, "sql", attrs...)
+}
@@ -0,0 +1,963 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MealRepository is the meal storage the server depends on. It lets handlers
+// run against any database, or a fake, instead of the package level db. The
+// package level meal functions are the same queries run on the package db.
+type MealRepository interface {
+	InsertMeal(ctx context.Context, meal Meal) (Meal, error)
+	GetMealsByUserAndDate(ctx context.Context, user User, inTime time.Time) ([]Meal, error)
+	GetMealsByUserAndDateSortedByType(ctx context.Context, user User, date time.Time) ([]Meal, error)
+	GetAllMeals(ctx context.Context) ([]Meal, error)
+	DeleteMealByUserAndId(ctx context.Context, user User, id string) error
+
+	BulkInsertMeals(ctx context.Context, meals []Meal) ([]Meal, error)
+	GetAllMealsByUser(ctx context.Context, user User) ([]Meal, error)
+	GetMealsByUserPaginated(ctx context.Context, user User, limit, offset int) ([]Meal, int, error)
+	SearchMealsByName(ctx context.Context, user User, query string, limit int) ([]Meal, error)
+	GetMealByIdAndUser(ctx context.Context, id int64, user User) (Meal, error)
+	GetMealsByUserAndDateRange(ctx context.Context, user User, from, to time.Time) ([]Meal, error)
+	GetMealsByUserAndWeek(ctx context.Context, user User, weekStart time.Time) ([]Meal, error)
+	GetWeeklyMealSummary(ctx context.Context, user User, weekStart time.Time) (map[string][]Meal, error)
+	GetMealsByUserAndMonth(ctx context.Context, user User, year int, month time.Month) ([]Meal, error)
+	GetMonthlyMealSummary(ctx context.Context, user User, year int, month time.Month) (map[string][]Meal, error)
+	GetMealsByUserAndType(ctx context.Context, user User, mealType MealType) ([]Meal, error)
+	GetMealCountByUser(ctx context.Context, user User) (int, error)
+	GetDistinctMealDates(ctx context.Context, user User) ([]time.Time, error)
+	GetTotalMealCount(ctx context.Context) (int, error)
+	GetFirstMealDate(ctx context.Context, user User) (time.Time, error)
+	GetLastMealDate(ctx context.Context, user User) (time.Time, error)
+	GetMealCountByUserAndDate(ctx context.Context, user User, inTime time.Time) (int, error)
+	GetMealCountByUserAndDateRange(ctx context.Context, user User, from, to time.Time) (int, error)
+	GetMealCountByType(ctx context.Context, user User, mealType MealType) (int, error)
+	GetMealCountByTypeAndDateRange(ctx context.Context, user User, mealType MealType, from, to time.Time) (int, error)
+	GetMealCountByTypeForUser(ctx context.Context, user User) (map[MealType]int, error)
+	GetMealStreak(ctx context.Context, user User) (int, error)
+	GetMealsByUserAndDayOfWeek(ctx context.Context, user User, dow int) ([]Meal, error)
+	GetMealCountsByDayOfWeek(ctx context.Context, user User) ([7]int, error)
+	GetCalorieAverageByDayOfWeek(ctx context.Context, user User) ([7]float64, error)
+	GetDailySummaryRows(ctx context.Context, user User, limit int) ([]DailySummaryRow, error)
+	GetMostFrequentMeals(ctx context.Context, user User, limit int) ([]FrequentMeal, error)
+	GetDailyCalorieTotals(ctx context.Context, user User, from, to time.Time) ([]DailyCalories, error)
+	GetMealsPerDay(ctx context.Context, user User, from, to time.Time) ([]DailyCount, error)
+	UpdateMeal(ctx context.Context, user User, meal Meal) (Meal, error)
+	CopyMealsToDate(ctx context.Context, user User, fromDate, toDate time.Time) (int, error)
+	SetMealPhoto(ctx context.Context, mealId int64, userId int64, path string) error
+	DuplicateMeal(ctx context.Context, mealId int64, userId int64, newTime time.Time) (Meal, error)
+	DeleteMealsByUserBefore(ctx context.Context, user User, before time.Time) (int64, error)
+	DeleteMealsByUserAndDate(ctx context.Context, user User, date time.Time) (int64, error)
+	GetMealsByTag(ctx context.Context, user User, name string) ([]Meal, error)
+	LoadTags(ctx context.Context, meals []Meal) ([]Meal, error)
+}
+
+// SQLiteMealRepository implements MealRepository on top of a SQLite database
+// opened with the Meals schema.
+type SQLiteMealRepository struct {
+	*sqlx.DB
+}
+
+func NewSQLiteMealRepository(db *sqlx.DB) SQLiteMealRepository {
+	return SQLiteMealRepository{DB: db}
+}
+
+// InsertMeal stores the meal along with its tags, creating any tag the user
+// does not have yet.
+func (r SQLiteMealRepository) InsertMeal(ctx context.Context, meal Meal) (Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return meal, err
+	}
+	defer tx.Rollback()
+
+	stampInsert(&meal)
+	res, err := tx.NamedExecContext(ctx, insertMealQuery, meal)
+	if err != nil {
+		return meal, err
+	}
+
+	lastInsertID, err := res.LastInsertId()
+	if err != nil {
+		return meal, err
+	}
+
+	user := User{Id: meal.UserID}
+	for _, name := range meal.Tags {
+		tag, err := createTag(ctx, tx, user, name)
+		if err != nil {
+			return meal, err
+		}
+
+		err = addTagToMeal(ctx, tx, user, lastInsertID, tag.Id)
+		if err != nil {
+			return meal, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return meal, err
+	}
+
+	meal.Id = lastInsertID
+
+	return meal, nil
+}
+
+func (r SQLiteMealRepository) GetMealsByUserAndDate(ctx context.Context, user User, inTime time.Time) ([]Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM Meals WHERE user_id = ? AND DATE(date_consumed) = DATE(?)`
+
+	var meals []Meal
+
+	err := r.DB.SelectContext(ctx, &meals, query, user.Id, inTime.Format(DateFormat))
+	if err != nil {
+		return meals, err
+	}
+
+	return meals, nil
+}
+
+// GetMealsByUserAndDateSortedByType returns the day's meals in the order they
+// are eaten through the day, breakfast first, and by time within each type.
+func (r SQLiteMealRepository) GetMealsByUserAndDateSortedByType(ctx context.Context, user User, date time.Time) ([]Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM Meals WHERE user_id = ? AND DATE(date_consumed) = DATE(?)
+	ORDER BY CASE meal_type
+		WHEN 'breakfast' THEN 1
+		WHEN 'lunch' THEN 2
+		WHEN 'dinner' THEN 3
+		ELSE 4
+	END, date_consumed`
+
+	var meals []Meal
+	err := r.DB.SelectContext(ctx, &meals, query, user.Id, date.Format(DateFormat))
+	if err != nil {
+		return meals, err
+	}
+
+	return meals, nil
+}
+
+func (r SQLiteMealRepository) GetAllMeals(ctx context.Context) ([]Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM Meals`
+
+	var meals []Meal
+	err := r.DB.SelectContext(ctx, &meals, query)
+	if err != nil {
+		return meals, err
+	}
+
+	return meals, nil
+}
+
+func (r SQLiteMealRepository) DeleteMealByUserAndId(ctx context.Context, user User, id string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `DELETE FROM Meals WHERE user_id = ? AND id = ?`
+
+	res, err := r.DB.ExecContext(ctx, query, user.Id, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r SQLiteMealRepository) BulkInsertMeals(ctx context.Context, meals []Meal) ([]Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	inserted := make([]Meal, 0, len(meals))
+
+	err := withTransaction(ctx, r.DB, func(tx *sqlx.Tx) error {
+		stmt, err := tx.PrepareNamed(insertMealQuery)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, meal := range meals {
+			stampInsert(&meal)
+			res, err := stmt.Exec(meal)
+			if err != nil {
+				return err
+			}
+
+			lastInsertID, err := res.LastInsertId()
+			if err != nil {
+				return err
+			}
+
+			user := User{Id: meal.UserID}
+			for _, name := range meal.Tags {
+				tag, err := createTag(ctx, tx, user, name)
+				if err != nil {
+					return err
+				}
+
+				err = addTagToMeal(ctx, tx, user, lastInsertID, tag.Id)
+				if err != nil {
+					return err
+				}
+			}
+
+			meal.Id = lastInsertID
+			inserted = append(inserted, meal)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return meals, err
+	}
+
+	return inserted, nil
+}
+
+func (r SQLiteMealRepository) GetAllMealsByUser(ctx context.Context, user User) ([]Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM Meals WHERE user_id = ? ORDER BY date_consumed`
+
+	var meals []Meal
+	err := r.DB.SelectContext(ctx, &meals, query, user.Id)
+	if err != nil {
+		return meals, err
+	}
+
+	return meals, nil
+}
+
+func (r SQLiteMealRepository) GetMealsByUserPaginated(ctx context.Context, user User, limit, offset int) ([]Meal, int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var total int
+	err := r.DB.GetContext(ctx, &total, `SELECT COUNT(*) FROM Meals WHERE user_id = ?`, user.Id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT * FROM Meals WHERE user_id = ? ORDER BY date_consumed DESC, id DESC LIMIT ? OFFSET ?`
+
+	var meals []Meal
+	err = r.DB.SelectContext(ctx, &meals, query, user.Id, limit, offset)
+	if err != nil {
+		return meals, total, err
+	}
+
+	return meals, total, nil
+}
+
+func (r SQLiteMealRepository) SearchMealsByName(ctx context.Context, user User, query string, limit int) ([]Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// wildcards typed by the user are matched literally
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query)
+
+	sqlQuery := `SELECT * FROM Meals WHERE user_id = ? AND name LIKE ? ESCAPE '\' ORDER BY date_consumed DESC LIMIT ?`
+
+	var meals []Meal
+	err := r.DB.SelectContext(ctx, &meals, sqlQuery, user.Id, "%"+escaped+"%", limit)
+	if err != nil {
+		return meals, err
+	}
+
+	return meals, nil
+}
+
+func (r SQLiteMealRepository) GetMealByIdAndUser(ctx context.Context, id int64, user User) (Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM Meals WHERE id = ? AND user_id = ?`
+
+	var meal Meal
+	err := r.DB.GetContext(ctx, &meal, query, id, user.Id)
+	if err != nil {
+		return meal, err
+	}
+
+	return meal, nil
+}
+
+func (r SQLiteMealRepository) GetMealsByUserAndDateRange(ctx context.Context, user User, from, to time.Time) ([]Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM Meals WHERE user_id = ? AND DATE(date_consumed) BETWEEN DATE(?) AND DATE(?) ORDER BY date_consumed`
+
+	var meals []Meal
+	err := r.DB.SelectContext(ctx, &meals, query, user.Id, from.Format(DateFormat), to.Format(DateFormat))
+	if err != nil {
+		return meals, err
+	}
+
+	return meals, nil
+}
+
+func (r SQLiteMealRepository) GetMealsByUserAndWeek(ctx context.Context, user User, weekStart time.Time) ([]Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM Meals WHERE user_id = ? AND DATE(date_consumed) >= DATE(?) AND DATE(date_consumed) < DATE(?, '+7 days') ORDER BY date_consumed`
+
+	start := weekStart.Format(DateFormat)
+
+	var meals []Meal
+	err := r.DB.SelectContext(ctx, &meals, query, user.Id, start, start)
+	if err != nil {
+		return meals, err
+	}
+
+	return meals, nil
+}
+
+func (r SQLiteMealRepository) GetWeeklyMealSummary(ctx context.Context, user User, weekStart time.Time) (map[string][]Meal, error) {
+	meals, err := r.GetMealsByUserAndWeek(ctx, user, weekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	days := make(map[string][]Meal)
+	for _, meal := range meals {
+		date := meal.DateConsumed[:len(DateFormat)]
+		days[date] = append(days[date], meal)
+	}
+
+	return days, nil
+}
+
+func (r SQLiteMealRepository) GetMealsByUserAndMonth(ctx context.Context, user User, year int, month time.Month) ([]Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM Meals WHERE user_id = ? AND strftime('%Y', date_consumed) = ? AND strftime('%m', date_consumed) = ? ORDER BY date_consumed`
+
+	var meals []Meal
+	err := r.DB.SelectContext(ctx, &meals, query, user.Id, fmt.Sprintf("%04d", year), fmt.Sprintf("%02d", int(month)))
+	if err != nil {
+		return meals, err
+	}
+
+	return meals, nil
+}
+
+func (r SQLiteMealRepository) GetMonthlyMealSummary(ctx context.Context, user User, year int, month time.Month) (map[string][]Meal, error) {
+	meals, err := r.GetMealsByUserAndMonth(ctx, user, year, month)
+	if err != nil {
+		return nil, err
+	}
+
+	days := make(map[string][]Meal)
+	for _, meal := range meals {
+		date := meal.DateConsumed[:len(DateFormat)]
+		days[date] = append(days[date], meal)
+	}
+
+	return days, nil
+}
+
+func (r SQLiteMealRepository) GetMealsByUserAndType(ctx context.Context, user User, mealType MealType) ([]Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM Meals WHERE user_id = ? AND meal_type = ? ORDER BY date_consumed DESC`
+
+	var meals []Meal
+	err := r.DB.SelectContext(ctx, &meals, query, user.Id, string(mealType))
+	if err != nil {
+		return meals, err
+	}
+
+	return meals, nil
+}
+
+func (r SQLiteMealRepository) GetMealCountByUser(ctx context.Context, user User) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT COUNT(*) FROM Meals WHERE user_id = ?`
+
+	var count int
+	err := r.DB.GetContext(ctx, &count, query, user.Id)
+	if err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
+
+func (r SQLiteMealRepository) GetDistinctMealDates(ctx context.Context, user User) ([]time.Time, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT DISTINCT DATE(date_consumed) AS day FROM Meals WHERE user_id = ? ORDER BY day DESC`
+
+	var days []string
+	err := r.DB.SelectContext(ctx, &days, query, user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	dates := make([]time.Time, 0, len(days))
+	for _, day := range days {
+		date, err := time.ParseInLocation(DateFormat, day, time.Local)
+		if err != nil {
+			return nil, err
+		}
+		dates = append(dates, date)
+	}
+
+	return dates, nil
+}
+
+func (r SQLiteMealRepository) GetTotalMealCount(ctx context.Context) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var count int
+	err := r.DB.GetContext(ctx, &count, `SELECT COUNT(*) FROM Meals`)
+	if err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
+
+func (r SQLiteMealRepository) GetFirstMealDate(ctx context.Context, user User) (time.Time, error) {
+	return r.getMealDate(ctx, user, `SELECT MIN(date_consumed) FROM Meals WHERE user_id = ?`)
+}
+
+func (r SQLiteMealRepository) GetLastMealDate(ctx context.Context, user User) (time.Time, error) {
+	return r.getMealDate(ctx, user, `SELECT MAX(date_consumed) FROM Meals WHERE user_id = ?`)
+}
+
+// getMealDate runs an aggregate over date_consumed, which is NULL when the
+// user has no meals.
+func (r SQLiteMealRepository) getMealDate(ctx context.Context, user User, query string) (time.Time, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var date sql.NullString
+	err := r.DB.GetContext(ctx, &date, query, user.Id)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if !date.Valid {
+		return time.Time{}, nil
+	}
+
+	return time.ParseInLocation(Timestamp, date.String, time.Local)
+}
+
+func (r SQLiteMealRepository) GetMealCountByUserAndDate(ctx context.Context, user User, inTime time.Time) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT COUNT(*) FROM Meals WHERE user_id = ? AND DATE(date_consumed) = DATE(?)`
+
+	var count int
+	err := r.DB.GetContext(ctx, &count, query, user.Id, inTime.Format(DateFormat))
+	if err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
+
+func (r SQLiteMealRepository) GetMealCountByUserAndDateRange(ctx context.Context, user User, from, to time.Time) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT COUNT(*) FROM Meals WHERE user_id = ? AND DATE(date_consumed) BETWEEN DATE(?) AND DATE(?)`
+
+	var count int
+	err := r.DB.GetContext(ctx, &count, query, user.Id, from.Format(DateFormat), to.Format(DateFormat))
+	if err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
+
+func (r SQLiteMealRepository) GetMealCountByType(ctx context.Context, user User, mealType MealType) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT COUNT(*) FROM Meals WHERE user_id = ? AND meal_type = ?`
+
+	var count int
+	err := r.DB.GetContext(ctx, &count, query, user.Id, string(mealType))
+	if err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
+
+func (r SQLiteMealRepository) GetMealCountByTypeAndDateRange(ctx context.Context, user User, mealType MealType, from, to time.Time) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT COUNT(*) FROM Meals WHERE user_id = ? AND meal_type = ? AND DATE(date_consumed) BETWEEN DATE(?) AND DATE(?)`
+
+	var count int
+	err := r.DB.GetContext(ctx, &count, query, user.Id, string(mealType), from.Format(DateFormat), to.Format(DateFormat))
+	if err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
+
+func (r SQLiteMealRepository) GetMealCountByTypeForUser(ctx context.Context, user User) (map[MealType]int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT meal_type, COUNT(*) AS count FROM Meals WHERE user_id = ? GROUP BY meal_type`
+
+	var rows []struct {
+		MealType MealType `db:"meal_type"`
+		Count    int      `db:"count"`
+	}
+
+	counts := make(map[MealType]int, len(MealTypes))
+	for _, mealType := range MealTypes {
+		counts[mealType] = 0
+	}
+
+	err := r.DB.SelectContext(ctx, &rows, query, user.Id)
+	if err != nil {
+		return counts, err
+	}
+
+	for _, row := range rows {
+		counts[row.MealType] = row.Count
+	}
+
+	return counts, nil
+}
+
+func (r SQLiteMealRepository) GetMealStreak(ctx context.Context, user User) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT DISTINCT DATE(date_consumed) AS date FROM Meals
+	WHERE user_id = ? AND DATE(date_consumed) <= DATE(?)
+	ORDER BY date DESC`
+
+	day := time.Now()
+
+	var dates []string
+	err := r.DB.SelectContext(ctx, &dates, query, user.Id, day.Format(DateFormat))
+	if err != nil {
+		return 0, err
+	}
+
+	streak := 0
+	for _, date := range dates {
+		if date != day.Format(DateFormat) {
+			break
+		}
+		streak++
+		day = day.AddDate(0, 0, -1)
+	}
+
+	return streak, nil
+}
+
+func (r SQLiteMealRepository) GetMealsByUserAndDayOfWeek(ctx context.Context, user User, dow int) ([]Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM Meals WHERE user_id = ? AND CAST(strftime('%w', date_consumed) AS INTEGER) = ?`
+
+	var meals []Meal
+	err := r.DB.SelectContext(ctx, &meals, query, user.Id, dow)
+	if err != nil {
+		return meals, err
+	}
+
+	return meals, nil
+}
+
+func (r SQLiteMealRepository) GetMealCountsByDayOfWeek(ctx context.Context, user User) ([7]int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT CAST(strftime('%w', date_consumed) AS INTEGER) AS dow, COUNT(*) AS count
+	FROM Meals WHERE user_id = ?
+	GROUP BY dow`
+
+	var rows []struct {
+		Dow   int `db:"dow"`
+		Count int `db:"count"`
+	}
+
+	var counts [7]int
+	err := r.DB.SelectContext(ctx, &rows, query, user.Id)
+	if err != nil {
+		return counts, err
+	}
+
+	for _, row := range rows {
+		counts[row.Dow] = row.Count
+	}
+
+	return counts, nil
+}
+
+func (r SQLiteMealRepository) GetCalorieAverageByDayOfWeek(ctx context.Context, user User) ([7]float64, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// total each day first, so the average is per day rather than per meal
+	query := `SELECT dow, AVG(total) AS average FROM (
+		SELECT CAST(strftime('%w', date_consumed) AS INTEGER) AS dow, SUM(calories) AS total
+		FROM Meals WHERE user_id = ? AND calories IS NOT NULL
+		GROUP BY DATE(date_consumed)
+	) GROUP BY dow`
+
+	var rows []struct {
+		Dow     int     `db:"dow"`
+		Average float64 `db:"average"`
+	}
+
+	var averages [7]float64
+	err := r.DB.SelectContext(ctx, &rows, query, user.Id)
+	if err != nil {
+		return averages, err
+	}
+
+	for _, row := range rows {
+		averages[row.Dow] = row.Average
+	}
+
+	return averages, nil
+}
+
+func (r SQLiteMealRepository) GetDailySummaryRows(ctx context.Context, user User, limit int) ([]DailySummaryRow, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT DATE(date_consumed) AS date, COUNT(*) AS meal_count, CAST(COALESCE(SUM(calories), 0) AS INTEGER) AS total_calories, 0.0 AS avg_rating
+	FROM Meals WHERE user_id = ?
+	GROUP BY DATE(date_consumed)
+	ORDER BY date DESC
+	LIMIT ?`
+
+	rows := []DailySummaryRow{}
+	err := r.DB.SelectContext(ctx, &rows, query, user.Id, limit)
+	if err != nil {
+		return rows, err
+	}
+
+	return rows, nil
+}
+
+func (r SQLiteMealRepository) GetMostFrequentMeals(ctx context.Context, user User, limit int) ([]FrequentMeal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// ties between meal types go to the one logged most recently
+	query := `SELECT m.name, COUNT(*) AS count, (
+		SELECT t.meal_type FROM Meals t WHERE t.user_id = ? AND t.name = m.name
+		GROUP BY t.meal_type
+		ORDER BY COUNT(*) DESC, MAX(t.date_consumed) DESC
+		LIMIT 1
+	) AS meal_type
+	FROM Meals m WHERE m.user_id = ?
+	GROUP BY m.name
+	ORDER BY count DESC, m.name
+	LIMIT ?`
+
+	meals := []FrequentMeal{}
+	err := r.DB.SelectContext(ctx, &meals, query, user.Id, user.Id, limit)
+	if err != nil {
+		return meals, err
+	}
+
+	return meals, nil
+}
+
+func (r SQLiteMealRepository) GetDailyCalorieTotals(ctx context.Context, user User, from, to time.Time) ([]DailyCalories, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT DATE(date_consumed) AS date, COALESCE(SUM(calories), 0) AS total
+	FROM Meals WHERE user_id = ? AND DATE(date_consumed) BETWEEN DATE(?) AND DATE(?)
+	GROUP BY DATE(date_consumed)
+	ORDER BY date`
+
+	rows := []DailyCalories{}
+	err := r.DB.SelectContext(ctx, &rows, query, user.Id, from.Format(DateFormat), to.Format(DateFormat))
+	if err != nil {
+		return rows, err
+	}
+
+	return rows, nil
+}
+
+func (r SQLiteMealRepository) GetMealsPerDay(ctx context.Context, user User, from, to time.Time) ([]DailyCount, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT DATE(date_consumed) AS date, COUNT(*) AS count
+	FROM Meals WHERE user_id = ? AND DATE(date_consumed) BETWEEN DATE(?) AND DATE(?)
+	GROUP BY DATE(date_consumed)`
+
+	var rows []DailyCount
+	err := r.DB.SelectContext(ctx, &rows, query, user.Id, from.Format(DateFormat), to.Format(DateFormat))
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Date] = row.Count
+	}
+
+	days := []DailyCount{}
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local)
+	end := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.Local)
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format(DateFormat)
+		days = append(days, DailyCount{Date: date, Count: counts[date]})
+	}
+
+	return days, nil
+}
+
+func (r SQLiteMealRepository) UpdateMeal(ctx context.Context, user User, meal Meal) (Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `UPDATE Meals SET name = :name, meal_type = :meal_type, calories = :calories, protein = :protein, carbs = :carbs, fat = :fat, notes = :notes, updated_at = CURRENT_TIMESTAMP
+	WHERE id = :id AND user_id = :user_id`
+
+	meal.UserID = user.Id
+	res, err := r.DB.NamedExecContext(ctx, query, meal)
+	if err != nil {
+		return meal, err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return meal, err
+	}
+
+	if updated == 0 {
+		return meal, sql.ErrNoRows
+	}
+
+	return r.GetMealByIdAndUser(ctx, meal.Id, user)
+}
+
+func (r SQLiteMealRepository) CopyMealsToDate(ctx context.Context, user User, fromDate, toDate time.Time) (int, error) {
+	meals, err := r.GetMealsByUserAndDate(ctx, user, fromDate)
+	if err != nil {
+		return 0, err
+	}
+	if len(meals) == 0 {
+		return 0, nil
+	}
+
+	meals, err = r.LoadTags(ctx, meals)
+	if err != nil {
+		return 0, err
+	}
+
+	copies := make([]Meal, 0, len(meals))
+	for _, meal := range meals {
+		consumed, err := meal.DateConsumedTime()
+		if err != nil {
+			return 0, err
+		}
+
+		at := time.Date(toDate.Year(), toDate.Month(), toDate.Day(),
+			consumed.Hour(), consumed.Minute(), consumed.Second(), 0, time.Local)
+
+		meal.Id = 0
+		meal.DateConsumed = at.Format(Timestamp)
+		copies = append(copies, meal)
+	}
+
+	inserted, err := r.BulkInsertMeals(ctx, copies)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(inserted), nil
+}
+
+func (r SQLiteMealRepository) SetMealPhoto(ctx context.Context, mealId int64, userId int64, path string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `UPDATE Meals SET photo_path = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ?`
+
+	res, err := r.DB.ExecContext(ctx, query, path, mealId, userId)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r SQLiteMealRepository) DuplicateMeal(ctx context.Context, mealId int64, userId int64, newTime time.Time) (Meal, error) {
+	user := User{Id: userId}
+
+	meal, err := r.GetMealByIdAndUser(ctx, mealId, user)
+	if err != nil {
+		return meal, err
+	}
+
+	tagged, err := r.LoadTags(ctx, []Meal{meal})
+	if err != nil {
+		return meal, err
+	}
+	meal = tagged[0]
+
+	meal.Id = 0
+	meal.PhotoPath = ""
+	meal.DateConsumed = newTime.Format(Timestamp)
+
+	return r.InsertMeal(ctx, meal)
+}
+
+func (r SQLiteMealRepository) DeleteMealsByUserBefore(ctx context.Context, user User, before time.Time) (int64, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `DELETE FROM Meals WHERE user_id = ? AND DATE(date_consumed) < DATE(?)`
+
+	res, err := r.DB.ExecContext(ctx, query, user.Id, before.Format(DateFormat))
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+func (r SQLiteMealRepository) DeleteMealsByUserAndDate(ctx context.Context, user User, date time.Time) (int64, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `DELETE FROM Meals WHERE user_id = ? AND DATE(date_consumed) = DATE(?)`
+
+	res, err := r.DB.ExecContext(ctx, query, user.Id, date.Format(DateFormat))
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+func (r SQLiteMealRepository) GetMealsByTag(ctx context.Context, user User, name string) ([]Meal, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT m.* FROM Meals m
+	JOIN MealTags mt ON mt.meal_id = m.id
+	JOIN Tags t ON t.id = mt.tag_id
+	WHERE m.user_id = ? AND t.name = ?
+	ORDER BY m.date_consumed DESC`
+
+	var meals []Meal
+	err := r.DB.SelectContext(ctx, &meals, query, user.Id, name)
+	if err != nil {
+		return meals, err
+	}
+
+	return r.LoadTags(ctx, meals)
+}
+
+func (r SQLiteMealRepository) LoadTags(ctx context.Context, meals []Meal) ([]Meal, error) {
+	if len(meals) == 0 {
+		return meals, nil
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	ids := make([]int64, len(meals))
+	for i, meal := range meals {
+		ids[i] = meal.Id
+	}
+
+	query, args, err := sqlx.In(`SELECT mt.meal_id, t.name FROM MealTags mt
+	JOIN Tags t ON t.id = mt.tag_id
+	WHERE mt.meal_id IN (?)
+	ORDER BY t.name`, ids)
+	if err != nil {
+		return meals, err
+	}
+
+	var rows []struct {
+		MealId int64  `db:"meal_id"`
+		Name   string `db:"name"`
+	}
+	err = r.DB.SelectContext(ctx, &rows, r.DB.Rebind(query), args...)
+	if err != nil {
+		return meals, err
+	}
+
+	byMeal := make(map[int64][]string)
+	for _, row := range rows {
+		byMeal[row.MealId] = append(byMeal[row.MealId], row.Name)
+	}
+
+	for i := range meals {
+		meals[i].Tags = byMeal[meals[i].Id]
+	}
+
+	return meals, nil
+}
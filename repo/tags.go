@@ -0,0 +1,168 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Tag is a free-form label, e.g. "home-cooked", that a user can put on any
+// number of their meals.
+type Tag struct {
+	Id     int64  `db:"id" json:"id"`
+	UserID int64  `db:"user_id" json:"user_id"`
+	Name   string `db:"name" json:"name"`
+}
+
+// tag names are unique per user rather than globally, so that two users can
+// both have a "cheat-day" tag
+var TagsSchema = `CREATE TABLE IF NOT EXISTS Tags (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES Users(id),
+	name TEXT NOT NULL,
+	UNIQUE (user_id, name)
+)`
+
+var MealTagsSchema = `CREATE TABLE IF NOT EXISTS MealTags (
+	meal_id INTEGER NOT NULL REFERENCES Meals(id) ON DELETE CASCADE,
+	tag_id INTEGER NOT NULL REFERENCES Tags(id) ON DELETE CASCADE,
+	PRIMARY KEY (meal_id, tag_id)
+)`
+
+// WithTags labels the meal, the tags are created by InsertMeal as needed.
+func WithTags(tags ...string) MealOption {
+	return func(m *Meal) {
+		m.Tags = tags
+	}
+}
+
+// ParseTags splits a comma separated list such as "home-cooked, cheat-day",
+// dropping blanks and duplicates.
+func ParseTags(str string) []string {
+	var tags []string
+	for _, tag := range strings.Split(str, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || slices.Contains(tags, tag) {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
+
+// CreateTag returns the user's tag called name, creating it if it does not
+// exist yet.
+func CreateTag(ctx context.Context, user User, name string) (Tag, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	return createTag(ctx, db, user, name)
+}
+
+// createTag runs on either the db or a transaction.
+func createTag(ctx context.Context, ext sqlx.ExtContext, user User, name string) (Tag, error) {
+	_, err := ext.ExecContext(ctx, `INSERT OR IGNORE INTO Tags (user_id, name) VALUES (?, ?)`, user.Id, name)
+	if err != nil {
+		return Tag{}, err
+	}
+
+	var tag Tag
+	err = sqlx.GetContext(ctx, ext, &tag, `SELECT * FROM Tags WHERE user_id = ? AND name = ?`, user.Id, name)
+	if err != nil {
+		return tag, err
+	}
+
+	return tag, nil
+}
+
+// AddTagToMeal links a tag to a meal, both of which must belong to user.
+// sql.ErrNoRows is returned when either does not.
+func AddTagToMeal(ctx context.Context, user User, mealId, tagId int64) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	return addTagToMeal(ctx, db, user, mealId, tagId)
+}
+
+func addTagToMeal(ctx context.Context, ext sqlx.ExtContext, user User, mealId, tagId int64) error {
+	query := `INSERT OR IGNORE INTO MealTags (meal_id, tag_id)
+	SELECT m.id, t.id FROM Meals m, Tags t
+	WHERE m.id = ? AND m.user_id = ? AND t.id = ? AND t.user_id = ?`
+
+	res, err := ext.ExecContext(ctx, query, mealId, user.Id, tagId, user.Id)
+	if err != nil {
+		return err
+	}
+
+	added, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// a link that already existed is ignored, so check ownership again
+	if added == 0 {
+		var count int
+		err = sqlx.GetContext(ctx, ext, &count, `SELECT COUNT(*) FROM MealTags mt
+		JOIN Meals m ON m.id = mt.meal_id
+		WHERE mt.meal_id = ? AND mt.tag_id = ? AND m.user_id = ?`, mealId, tagId, user.Id)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return sql.ErrNoRows
+		}
+	}
+
+	return nil
+}
+
+// RemoveTagFromMeal unlinks a tag from one of the user's meals. The tag itself
+// is kept for other meals.
+func RemoveTagFromMeal(ctx context.Context, user User, mealId, tagId int64) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `DELETE FROM MealTags WHERE meal_id = ? AND tag_id = ?
+	AND meal_id IN (SELECT id FROM Meals WHERE user_id = ?)`
+
+	_, err := db.ExecContext(ctx, query, mealId, tagId, user.Id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetTagsForMeal(ctx context.Context, user User, mealId int64) ([]Tag, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT t.* FROM Tags t
+	JOIN MealTags mt ON mt.tag_id = t.id
+	WHERE mt.meal_id = ? AND t.user_id = ?
+	ORDER BY t.name`
+
+	tags := []Tag{}
+	err := db.SelectContext(ctx, &tags, query, mealId, user.Id)
+	if err != nil {
+		return tags, err
+	}
+
+	return tags, nil
+}
+
+// GetMealsByTag returns the user's meals labelled with the named tag, most
+// recent first, with their tags loaded.
+func GetMealsByTag(ctx context.Context, user User, name string) ([]Meal, error) {
+	return SQLiteMealRepository{DB: db}.GetMealsByTag(ctx, user, name)
+}
+
+// LoadTags fills in Tags on each meal. Queries return meals without tags, so
+// callers that need them opt in with this.
+func LoadTags(ctx context.Context, meals []Meal) ([]Meal, error) {
+	return SQLiteMealRepository{DB: db}.LoadTags(ctx, meals)
+}
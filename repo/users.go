@@ -1,15 +1,26 @@
 package repo
 
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
 type User struct {
-	Id       int64  `db:"id"`
-	Email    string `db:"email"`
-	Password string `db:"password"`
+	Id                   int64  `db:"id" json:"id"`
+	Email                string `db:"email" json:"email"`
+	Password             string `db:"password" json:"-"` // bcrypt hash, never sent to clients
+	LastPasswordChangeAt string `db:"last_password_change_at" json:"-"`
 }
 
 var UsersSchema = `CREATE TABLE IF NOT EXISTS Users(
 	id INTEGER PRIMARY KEY,
 	email TEXT NOT NULL UNIQUE,
-	password TEXT NOT NULL
+	password TEXT NOT NULL,
+	last_password_change_at TEXT DEFAULT (datetime('now'))
 	)`
 
 func NewUser(email, password string) User {
@@ -19,10 +30,15 @@ func NewUser(email, password string) User {
 	}
 }
 
-func InsertUser(user User) (User, error) {
-	query := "INSERT INTO Users (email, password) VALUES (:email, :password)"
+func InsertUser(ctx context.Context, user User) (User, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// last_password_change_at is set explicitly as databases created before the
+	// column existed were migrated without a default
+	query := "INSERT INTO Users (email, password, last_password_change_at) VALUES (:email, :password, datetime('now'))"
 
-	res, err := db.NamedExec(query, user)
+	res, err := db.NamedExecContext(ctx, query, user)
 	if err != nil {
 		return user, err
 	}
@@ -37,14 +53,176 @@ func InsertUser(user User) (User, error) {
 	return user, nil
 }
 
-func GetUserByEmail(email string) (User, error) {
+func GetUserByEmail(ctx context.Context, email string) (User, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
 	query := "SELECT * FROM Users WHERE email = ?"
 
 	var user User
-	err := db.Get(&user, query, email)
+	err := db.GetContext(ctx, &user, query, email)
 	if err != nil {
 		return user, err
 	}
 
 	return user, nil
 }
+
+// GetUserById returns the full user row, including the password hash so that
+// callers can verify a password. Never encode the row itself to a client.
+func GetUserById(ctx context.Context, id int64) (User, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := "SELECT * FROM Users WHERE id = ?"
+
+	var user User
+	err := db.GetContext(ctx, &user, query, id)
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// UpdateUserPassword stores a new, already hashed, password for the user and
+// restarts the password expiry clock.
+func UpdateUserPassword(ctx context.Context, userId int64, hashedPassword string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := "UPDATE Users SET password = ?, last_password_change_at = datetime('now') WHERE id = ?"
+
+	res, err := db.ExecContext(ctx, query, hashedPassword, userId)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteUser removes the user and all of their meals in a single transaction.
+// Meals and tags are deleted first, as their foreign keys to Users would
+// otherwise reject removing the user.
+func DeleteUser(ctx context.Context, userId int64) error {
+	return WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec("DELETE FROM Meals WHERE user_id = ?", userId)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("DELETE FROM Tags WHERE user_id = ?", userId)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.Exec("DELETE FROM Users WHERE id = ?", userId)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if deleted == 0 {
+			return sql.ErrNoRows
+		}
+
+		return nil
+	})
+}
+
+// IsPasswordExpired reports whether the user's password is older than maxAge.
+// A maxAge of zero or less disables expiry. A missing or unreadable timestamp
+// counts as expired so that bad data cannot be used to skip rotation.
+func IsPasswordExpired(user User, maxAge time.Duration) bool {
+	if maxAge <= 0 {
+		return false
+	}
+
+	changedAt, err := time.Parse(Timestamp, user.LastPasswordChangeAt)
+	if err != nil {
+		return true
+	}
+
+	return time.Since(changedAt) > maxAge
+}
+
+// SearchUsers returns a page of the users whose email starts with query,
+// ordered by id, along with how many users match in total.
+func SearchUsers(ctx context.Context, query string, limit, offset int) ([]User, int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// wildcards typed by the admin are matched literally
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query) + "%"
+
+	var total int
+	err := db.GetContext(ctx, &total, `SELECT COUNT(*) FROM Users WHERE email LIKE ? ESCAPE '\'`, pattern)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sqlQuery := `SELECT * FROM Users WHERE email LIKE ? ESCAPE '\' ORDER BY id LIMIT ? OFFSET ?`
+
+	var users []User
+	err = db.SelectContext(ctx, &users, sqlQuery, pattern, limit, offset)
+	if err != nil {
+		return users, total, err
+	}
+
+	return users, total, nil
+}
+
+func GetUserCount(ctx context.Context) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var count int
+	err := db.GetContext(ctx, &count, `SELECT COUNT(*) FROM Users`)
+	if err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
+
+// UserMealCount is how many meals a user logged on a day.
+type UserMealCount struct {
+	UserID    int64  `db:"user_id" json:"user_id"`
+	Email     string `db:"email" json:"email"`
+	MealCount int    `db:"meal_count" json:"meal_count"`
+}
+
+// GetUsersWithMealCountsOnDate lists every user who logged a meal on date with
+// their meal count, most active first. It is only meant for admin use.
+func GetUsersWithMealCountsOnDate(ctx context.Context, date time.Time) ([]UserMealCount, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	query := `SELECT u.id AS user_id, u.email, COUNT(m.id) AS meal_count
+	FROM Users u
+	JOIN Meals m ON m.user_id = u.id
+	WHERE DATE(m.date_consumed) = DATE(?)
+	GROUP BY u.id, u.email
+	ORDER BY meal_count DESC, u.id`
+
+	counts := []UserMealCount{}
+	err := db.SelectContext(ctx, &counts, query, date.Format(DateFormat))
+	if err != nil {
+		return counts, err
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,130 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"modernc.org/sqlite"
+)
+
+func TestInsertUser(t *testing.T) {
+	resetDB(t)
+
+	first := insertTestUser(t, "a@b.com")
+	second := insertTestUser(t, "c@d.com")
+
+	if first.Id == 0 || second.Id == 0 {
+		t.Fatalf("InsertUser left Id unset: %d, %d", first.Id, second.Id)
+	}
+	if first.Id == second.Id {
+		t.Errorf("both users got Id %d", first.Id)
+	}
+}
+
+func TestGetUserByEmail(t *testing.T) {
+	resetDB(t)
+	alice := insertTestUser(t, "alice@example.com")
+	bob := insertTestUser(t, "bob@example.com")
+
+	tests := []struct {
+		email string
+		want  User
+	}{
+		{"alice@example.com", alice},
+		{"bob@example.com", bob},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			user, err := GetUserByEmail(context.Background(), tt.email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Id != tt.want.Id || user.Email != tt.want.Email {
+				t.Errorf("got user %d %q, want %d %q", user.Id, user.Email, tt.want.Id, tt.want.Email)
+			}
+		})
+	}
+}
+
+func TestInsertUserDuplicateEmail(t *testing.T) {
+	resetDB(t)
+	insertTestUser(t, "a@b.com")
+
+	_, err := InsertUser(context.Background(), NewUser("a@b.com", "another-hash"))
+
+	// the register handler relies on this code to report a taken email
+	var liteErr *sqlite.Error
+	if !errors.As(err, &liteErr) {
+		t.Fatalf("err = %v, want a *sqlite.Error", err)
+	}
+	if code := liteErr.Code(); code != 2067 {
+		t.Errorf("code = %d, want 2067 (SQLITE_CONSTRAINT_UNIQUE)", code)
+	}
+}
+
+func TestGetUserByEmailMissing(t *testing.T) {
+	resetDB(t)
+	insertTestUser(t, "a@b.com")
+
+	_, err := GetUserByEmail(context.Background(), "nobody@b.com")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	resetDB(t)
+
+	// 25 alices and 25 bobs, interleaved so that ids alone do not group them
+	for i := 0; i < 25; i++ {
+		insertTestUser(t, fmt.Sprintf("alice%02d@example.com", i))
+		insertTestUser(t, fmt.Sprintf("bob%02d@example.com", i))
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		limit     int
+		offset    int
+		wantCount int
+		wantTotal int
+	}{
+		{"first page", "alice", 10, 0, 10, 25},
+		{"last partial page", "alice", 10, 20, 5, 25},
+		{"past the end", "alice", 10, 30, 0, 25},
+		{"narrower prefix", "bob1", 20, 0, 10, 10},
+		{"whole address", "bob07@example.com", 20, 0, 1, 1},
+		{"prefix only, not a substring", "example.com", 20, 0, 0, 0},
+		{"wildcards are literal", "%", 20, 0, 0, 0},
+		{"empty query matches everyone", "", 100, 0, 50, 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, total, err := SearchUsers(context.Background(), tt.query, tt.limit, tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", total, tt.wantTotal)
+			}
+			if len(users) != tt.wantCount {
+				t.Fatalf("got %d users, want %d", len(users), tt.wantCount)
+			}
+
+			for i, user := range users {
+				if !strings.HasPrefix(user.Email, tt.query) {
+					t.Errorf("%q does not start with %q", user.Email, tt.query)
+				}
+				if i > 0 && user.Id <= users[i-1].Id {
+					t.Errorf("users are not ordered by id: %d after %d", user.Id, users[i-1].Id)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+const FaviconSize = 32
+
+// buildFavicon renders a solid square PNG in the given hex colour (e.g. "#4caf50").
+func buildFavicon(primaryColor string) ([]byte, error) {
+	hex := strings.TrimPrefix(primaryColor, "#")
+
+	var r, g, b uint8
+	if _, err := fmt.Sscanf(hex, "%02x%02x%02x", &r, &g, &b); err != nil || len(hex) != 6 {
+		return nil, fmt.Errorf("invalid favicon colour %q", primaryColor)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, FaviconSize, FaviconSize))
+	fill := color.RGBA{R: r, G: g, B: b, A: 0xff}
+	for y := 0; y < FaviconSize; y++ {
+		for x := 0; x < FaviconSize; x++ {
+			img.Set(x, y, fill)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// staticETags hashes every file under dir in fsys, keyed by the URL path it is
+// served on, e.g. "/static/css/styles.css". The tags are weak because
+// GzipMiddleware may change the encoding of the same content.
+func staticETags(fsys fs.FS, dir string) (map[string]string, error) {
+	etags := make(map[string]string)
+	err := fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		etags[path.Join("/", name)] = `W/"` + hex.EncodeToString(sum[:16]) + `"`
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("hashing static files: %w", err)
+	}
+
+	return etags, nil
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/connorkuljis/food-diary/repo"
+)
+
+// Config holds the settings the server is started with.
+type Config struct {
+	Port           string        // from $PORT, defaults to Port
+	SessionSecret  string        // key used to sign session cookies, required outside of dev mode
+	DBPath         string        // empty uses repo.DefaultDBPath
+	DevMode        bool          // enables development only behaviour such as /debug/pprof
+	PprofToken     string        // when set, /debug routes require this bearer token
+	UploadDir      string        // writable directory for user uploaded files, served at /uploads
+	AdminUserID    int64         // user allowed to use the /api/admin routes, 0 disables them
+	LogLevel       slog.Level    // from $LOG_LEVEL, debug in dev mode and info otherwise
+	QueryTimeout   time.Duration // how long a single database call may take before the request gets a 503
+	PasswordMaxAge time.Duration // passwords older than this must be changed before going on, 0 disables it
+	SessionBindIP  bool          // sign out sessions used from another IP than they were signed in from
+	SessionBindUA  bool          // sign out sessions used with another User-Agent than they were signed in with
+}
+
+// ConfigFromEnv builds a Config from environment variables, using defaults for
+// anything that is not set.
+func ConfigFromEnv() Config {
+	devMode, _ := strconv.ParseBool(os.Getenv("DEV_MODE"))
+	adminUserID, _ := strconv.ParseInt(os.Getenv("ADMIN_USER_ID"), 10, 64)
+	bindIP, _ := strconv.ParseBool(os.Getenv("SESSION_BIND_IP"))
+	bindUA, _ := strconv.ParseBool(os.Getenv("SESSION_BIND_UA"))
+
+	cfg := Config{
+		Port:          os.Getenv("PORT"),
+		SessionSecret: os.Getenv("SESSION_SECRET"),
+		DBPath:        os.Getenv("DB_PATH"),
+		DevMode:       devMode,
+		PprofToken:    os.Getenv("PPROF_TOKEN"),
+		UploadDir:     os.Getenv("UPLOAD_DIR"),
+		AdminUserID:   adminUserID,
+		SessionBindIP: bindIP,
+		SessionBindUA: bindUA,
+	}
+
+	// accepts slog's names such as "debug" or "warn", an unknown level is ignored
+	if cfg.LogLevel.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))) != nil {
+		cfg.LogLevel = slog.LevelInfo
+		if devMode {
+			cfg.LogLevel = slog.LevelDebug
+		}
+	}
+
+	cfg.QueryTimeout, _ = time.ParseDuration(os.Getenv("QUERY_TIMEOUT"))
+	if cfg.QueryTimeout <= 0 {
+		cfg.QueryTimeout = repo.DefaultQueryTimeout
+	}
+
+	// a missing or invalid value leaves expiry disabled
+	cfg.PasswordMaxAge, _ = time.ParseDuration(os.Getenv("PASSWORD_MAX_AGE"))
+
+	if cfg.Port == "" {
+		cfg.Port = Port
+	}
+
+	if cfg.UploadDir == "" {
+		cfg.UploadDir = UploadDirName
+	}
+
+	return cfg
+}
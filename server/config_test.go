@@ -0,0 +1,51 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/connorkuljis/food-diary/repo"
+)
+
+func TestConfigFromEnvPort(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		want string
+	}{
+		{"from $PORT", "9999", "9999"},
+		{"default when unset", "", Port},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PORT", tt.env)
+
+			if got := ConfigFromEnv().Port; got != tt.want {
+				t.Errorf("Config.Port = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFromEnvQueryTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		want time.Duration
+	}{
+		{"from $QUERY_TIMEOUT", "5s", 5 * time.Second},
+		{"default when unset", "", repo.DefaultQueryTimeout},
+		{"default when invalid", "soon", repo.DefaultQueryTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("QUERY_TIMEOUT", tt.env)
+
+			if got := ConfigFromEnv().QueryTimeout; got != tt.want {
+				t.Errorf("Config.QueryTimeout = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/connorkuljis/food-diary/repo"
+)
+
+// MealCSVHeader lists the columns of a meals export. The first four are the
+// original export format, which imports still accept without a header.
+var MealCSVHeader = []string{
+	"id", "name", "meal_type", "date_consumed",
+	"quantity", "unit", "notes",
+	"calories", "protein", "carbs", "fat",
+	"tags", "photo_path", "created_at", "updated_at",
+}
+
+// csvFormulaPrefixes start a cell that spreadsheets evaluate as a formula.
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvSafe stops a user supplied cell from being run as a spreadsheet formula
+// by prefixing it with a quote, see csvUnescape.
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune(csvFormulaPrefixes, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// csvUnescape undoes csvSafe so that exported meals import unchanged.
+func csvUnescape(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune(csvFormulaPrefixes, rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
+
+func formatOptionalFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+// MealCSVRecord is the meal as a row in MealCSVHeader order. Tags must have
+// been loaded with LoadTags to be exported.
+func MealCSVRecord(meal repo.Meal) []string {
+	return []string{
+		strconv.FormatInt(meal.Id, 10),
+		csvSafe(meal.Name),
+		meal.MealType,
+		meal.DateConsumed,
+		strconv.FormatFloat(meal.Quantity, 'f', -1, 64),
+		csvSafe(meal.Unit),
+		csvSafe(meal.Notes),
+		formatOptionalFloat(meal.Calories),
+		formatOptionalFloat(meal.Protein),
+		formatOptionalFloat(meal.Carbs),
+		formatOptionalFloat(meal.Fat),
+		csvSafe(strings.Join(meal.Tags, ", ")),
+		meal.PhotoPath,
+		meal.CreatedAt.Format(repo.Timestamp),
+		meal.UpdatedAt.Format(repo.Timestamp),
+	}
+}
+
+var errCSVMissingField = errors.New("missing name or unknown meal type")
+
+// ParseMealCSVRecord builds a new meal for userId from an imported row. columns
+// maps each column name in the file's header to its index. The id, photo and
+// audit columns are ignored, the meal is stored as new.
+func ParseMealCSVRecord(columns map[string]int, record []string, userId int64) (repo.Meal, error) {
+	get := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return csvUnescape(record[i])
+		}
+		return ""
+	}
+
+	name, mealType := get("name"), repo.MealType(get("meal_type"))
+	if name == "" || !repo.IsValidMealType(mealType) {
+		return repo.Meal{}, errCSVMissingField
+	}
+
+	consumed, err := time.ParseInLocation(repo.Timestamp, get("date_consumed"), time.Local)
+	if err != nil {
+		return repo.Meal{}, err
+	}
+
+	var nutrition [4]*float64
+	for i, field := range []string{"calories", "protein", "carbs", "fat"} {
+		nutrition[i], err = ParseOptionalFloat(get(field))
+		if err != nil {
+			return repo.Meal{}, fmt.Errorf("%s must be a number", field)
+		}
+	}
+
+	opts := []repo.MealOption{repo.WithNotes(get("notes")), repo.WithTags(repo.ParseTags(get("tags"))...)}
+
+	quantity, err := ParseOptionalFloat(get("quantity"))
+	if err != nil {
+		return repo.Meal{}, errors.New("quantity must be a number")
+	}
+	if quantity != nil {
+		opts = append(opts, repo.WithQuantity(*quantity, get("unit")))
+	}
+
+	return repo.NewMealWithNutrition(name, userId, mealType, consumed, nutrition[0], nutrition[1], nutrition[2], nutrition[3], opts...), nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/connorkuljis/food-diary/repo"
+)
+
+func TestExportCSV(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+	other := newTestUser(t, "c@d.com")
+
+	calories, protein := 420.0, 12.5
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.Local)
+	meals := []repo.Meal{
+		repo.NewMealWithNutrition("soup", user.Id, repo.Lunch, at, &calories, &protein, nil, nil,
+			repo.WithNotes("with bread, too salty"), repo.WithQuantity(1.5, "bowl"), repo.WithTags("home", "warm")),
+		repo.NewMeal("=SUM(A1)", user.Id, repo.Snacks, at.Add(time.Hour)),
+		repo.NewMeal("not mine", other.Id, repo.Dinner, at),
+	}
+	for _, meal := range meals {
+		if _, err := repo.InsertMeal(context.Background(), meal); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/meals/export.csv", nil), sessionCookie(t, s, user.Id))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/csv" {
+		t.Errorf("Content-Type = %q, want text/csv", got)
+	}
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid CSV: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d rows, want the header and the user's 2 meals", len(records))
+	}
+	if got := strings.Join(records[0], ","); got != strings.Join(MealCSVHeader, ",") {
+		t.Fatalf("header = %s, want %s", got, strings.Join(MealCSVHeader, ","))
+	}
+
+	columns := make(map[string]int, len(records[0]))
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	rows := make(map[string][]string)
+	for _, record := range records[1:] {
+		rows[record[columns["meal_type"]]] = record
+	}
+
+	soup := rows["lunch"]
+	want := map[string]string{
+		"name":          "soup",
+		"date_consumed": "2024-03-01 12:30:00",
+		"quantity":      "1.5",
+		"unit":          "bowl",
+		"notes":         "with bread, too salty",
+		"calories":      "420",
+		"protein":       "12.5",
+		"carbs":         "",
+		"tags":          "home, warm",
+	}
+	for column, value := range want {
+		if got := soup[columns[column]]; got != value {
+			t.Errorf("%s = %q, want %q", column, got, value)
+		}
+	}
+
+	// the formula is quoted so spreadsheets show it as text
+	if got := rows["snacks"][columns["name"]]; got != "'=SUM(A1)" {
+		t.Errorf("formula name exported as %q, want %q", got, "'=SUM(A1)")
+	}
+
+	// and every row imports back as the meal it came from
+	for _, record := range records[1:] {
+		meal, err := ParseMealCSVRecord(columns, record, user.Id)
+		if err != nil {
+			t.Fatalf("importing %v: %v", record, err)
+		}
+		if meal.Name != "soup" && meal.Name != "=SUM(A1)" {
+			t.Errorf("imported name %q", meal.Name)
+		}
+	}
+}
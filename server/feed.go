@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/connorkuljis/food-diary/repo"
+	"github.com/gorilla/feeds"
+)
+
+// BuildAtomFeed renders the user's meals as an Atom feed, newest first. Each
+// entry links to the history page for the day the meal was eaten.
+func BuildAtomFeed(user repo.User, meals []repo.Meal, baseURL string) ([]byte, error) {
+	feed := &feeds.Feed{
+		Title: "Food Diary",
+		Link:  &feeds.Link{Href: baseURL + "/today"},
+	}
+
+	for i := len(meals) - 1; i >= 0; i-- {
+		meal := meals[i]
+
+		consumed, err := meal.DateConsumedTime()
+		if err != nil {
+			return nil, err
+		}
+
+		link := baseURL + "/history?date=" + consumed.Format(repo.DateFormat)
+		feed.Items = append(feed.Items, &feeds.Item{
+			Id:      fmt.Sprintf("%s#meal-%d", link, meal.Id),
+			Title:   fmt.Sprintf("%s: %s", meal.MealType, meal.Name),
+			Link:    &feeds.Link{Href: link},
+			Updated: consumed,
+		})
+
+		if consumed.After(feed.Updated) {
+			feed.Updated = consumed
+		}
+	}
+
+	atom, err := feed.ToAtom()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(atom), nil
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/connorkuljis/food-diary/repo"
+)
+
+func TestMealsFeed(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+	other := newTestUser(t, "c@d.com")
+
+	at := time.Date(2024, 3, 1, 8, 0, 0, 0, time.Local)
+	meals := []repo.Meal{
+		repo.NewMeal("toast", user.Id, repo.Breakfast, at),
+		repo.NewMeal("soup", user.Id, repo.Lunch, at.Add(4*time.Hour)),
+		repo.NewMeal("not mine", other.Id, repo.Dinner, at),
+	}
+	if _, err := repo.BulkInsertMeals(context.Background(), meals); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/feed/meals.atom", nil), sessionCookie(t, s, user.Id))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var feed struct {
+		Entries []struct {
+			Title string `xml:"title"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("feed is not valid XML: %v", err)
+	}
+
+	// newest first, and only the signed in user's meals
+	want := []string{string(repo.Lunch) + ": soup", string(repo.Breakfast) + ": toast"}
+	if len(feed.Entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(feed.Entries), len(want))
+	}
+	for i, entry := range feed.Entries {
+		if entry.Title != want[i] {
+			t.Errorf("entry %d title = %q, want %q", i, entry.Title, want[i])
+		}
+	}
+}
+
+func TestMealsFeedSignedOut(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/feed/meals.atom", nil), nil)
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"flag"
+	"os"
+
+	fooddiary "github.com/connorkuljis/food-diary"
+	"github.com/connorkuljis/food-diary/repo"
+)
+
+// The flags are registered up front so that main can parse the command line,
+// and act on its own flags, before NewServerFromFlags opens the database.
+var (
+	dbPathFlag = flag.String("db", "", "SQLite database path when $DB_PATH is not set")
+	portFlag   = flag.String("port", "", "port to listen on when $PORT is not set (default "+Port+")")
+	secretFlag = flag.String("secret", "", "session signing key when $SESSION_SECRET is not set")
+)
+
+// NewServerFromFlags parses the command line, unless main already has, and
+// returns a Server that is ready to serve: templates compiled, routes
+// registered and the database opened. Each flag is a fallback for its
+// environment variable, which wins when set, as that is what hosting platforms
+// configure.
+func NewServerFromFlags() (*Server, error) {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+
+	opts := []ServerOption{WithConfig(ConfigFromEnv())}
+	if os.Getenv("PORT") == "" && *portFlag != "" {
+		opts = append(opts, WithPort(*portFlag))
+	}
+	if os.Getenv("SESSION_SECRET") == "" && *secretFlag != "" {
+		opts = append(opts, WithSessionSecret([]byte(*secretFlag)))
+	}
+
+	s, err := NewServerWithOptions(fooddiary.FS, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if os.Getenv("DB_PATH") == "" && *dbPathFlag != "" {
+		s.Config.DBPath = *dbPathFlag
+	}
+
+	if err := s.PreloadTemplates(); err != nil {
+		return nil, err
+	}
+
+	dbConfig := repo.DBConfig{
+		Path:         s.Config.DBPath,
+		Logger:       s.Logger,
+		QueryTimeout: s.Config.QueryTimeout,
+	}
+	if err := repo.InitDBWithConfig(dbConfig); err != nil {
+		return nil, err
+	}
+	s.Logger.Info("using database", "path", repo.DBPath())
+	s.Meals = repo.NewSQLiteMealRepository(repo.DB())
+
+	s.Routes()
+
+	return s, nil
+}
@@ -11,13 +11,23 @@ const (
 	// HTML Views
 	TodayHTML    HTMLFile = "templates/views/today.html"
 	HistoryHTML  HTMLFile = "templates/views/history.html"
+	WeeklyHTML   HTMLFile = "templates/views/weekly.html"
+	MonthlyHTML  HTMLFile = "templates/views/monthly.html"
 	LoginHTML    HTMLFile = "templates/views/login.html"
 	RegisterHTML HTMLFile = "templates/views/register.html"
+	NotFoundHTML HTMLFile = "templates/views/error.html"
+
+	ChangePasswordHTML HTMLFile = "templates/views/change-password.html"
 
 	// HTML Components
-	NavHTML            HTMLFile = "templates/components/nav.html"
-	TableHTMLComponent HTMLFile = "templates/components/table.html"
-	ModalHTMLComponent HTMLFile = "templates/components/modal.html"
+	NavHTML                HTMLFile = "templates/components/nav.html"
+	FlashHTMLComponent     HTMLFile = "templates/components/flash.html"
+	TableHTMLComponent     HTMLFile = "templates/components/table.html"
+	ModalHTMLComponent     HTMLFile = "templates/components/modal.html"
+	StatsHTMLComponent     HTMLFile = "templates/components/stats.html"
+	DayOfWeekHTMLComponent HTMLFile = "templates/components/day-of-week.html"
+
+	FoodOptionsHTMLComponent HTMLFile = "templates/components/food-options.html"
 )
 
 // Views
@@ -26,6 +36,7 @@ var LoginView = []HTMLFile{
 	LayoutHTML,
 	HeadHTML,
 	NavHTML,
+	FlashHTMLComponent,
 	LoginHTML,
 }
 
@@ -34,6 +45,7 @@ var RegisterView = []HTMLFile{
 	LayoutHTML,
 	HeadHTML,
 	NavHTML,
+	FlashHTMLComponent,
 	RegisterHTML,
 }
 
@@ -42,9 +54,12 @@ var TodayView = []HTMLFile{
 	LayoutHTML,
 	RootHTML,
 	NavHTML,
+	FlashHTMLComponent,
 	TodayHTML,
 	TableHTMLComponent,
 	ModalHTMLComponent,
+	StatsHTMLComponent,
+	DayOfWeekHTMLComponent,
 }
 
 var HistoryView = []HTMLFile{
@@ -52,6 +67,59 @@ var HistoryView = []HTMLFile{
 	LayoutHTML,
 	RootHTML,
 	NavHTML,
+	FlashHTMLComponent,
 	HistoryHTML,
 	TableHTMLComponent,
+	StatsHTMLComponent,
+}
+
+var WeeklyView = []HTMLFile{
+	HeadHTML,
+	LayoutHTML,
+	RootHTML,
+	NavHTML,
+	FlashHTMLComponent,
+	WeeklyHTML,
+	TableHTMLComponent,
+}
+
+var MonthlyView = []HTMLFile{
+	HeadHTML,
+	LayoutHTML,
+	RootHTML,
+	NavHTML,
+	FlashHTMLComponent,
+	MonthlyHTML,
+	TableHTMLComponent,
+}
+
+var ChangePasswordView = []HTMLFile{
+	RootHTML,
+	LayoutHTML,
+	HeadHTML,
+	NavHTML,
+	FlashHTMLComponent,
+	ChangePasswordHTML,
+}
+
+var ErrorView = []HTMLFile{
+	RootHTML,
+	LayoutHTML,
+	HeadHTML,
+	NavHTML,
+	FlashHTMLComponent,
+	NotFoundHTML,
+}
+
+// Views maps a name to each view so they can all be compiled at start up.
+var Views = map[string][]HTMLFile{
+	"login.html":    LoginView,
+	"register.html": RegisterView,
+	"today.html":    TodayView,
+	"history.html":  HistoryView,
+	"weekly.html":   WeeklyView,
+	"monthly.html":  MonthlyView,
+	"error.html":    ErrorView,
+
+	"change-password.html": ChangePasswordView,
 }
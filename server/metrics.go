@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// LatencyWindow is the number of most recent samples kept for each route.
+const LatencyWindow = 1000
+
+// LatencyTracker keeps a fixed-size ring buffer of response times per route.
+type LatencyTracker struct {
+	mu     sync.RWMutex
+	routes map[string]*latencyRing
+}
+
+type latencyRing struct {
+	samples []time.Duration
+	next    int
+}
+
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{routes: make(map[string]*latencyRing)}
+}
+
+// Record adds a sample for route, overwriting the oldest once the window is full.
+func (t *LatencyTracker) Record(route string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ring, ok := t.routes[route]
+	if !ok {
+		ring = &latencyRing{samples: make([]time.Duration, 0, LatencyWindow)}
+		t.routes[route] = ring
+	}
+
+	if len(ring.samples) < LatencyWindow {
+		ring.samples = append(ring.samples, d)
+		return
+	}
+
+	ring.samples[ring.next] = d
+	ring.next = (ring.next + 1) % LatencyWindow
+}
+
+// Routes returns every route that has at least one sample.
+func (t *LatencyTracker) Routes() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	routes := make([]string, 0, len(t.routes))
+	for route := range t.routes {
+		routes = append(routes, route)
+	}
+	slices.Sort(routes)
+
+	return routes
+}
+
+func (t *LatencyTracker) P50(route string) time.Duration { return t.percentile(route, 50) }
+func (t *LatencyTracker) P95(route string) time.Duration { return t.percentile(route, 95) }
+func (t *LatencyTracker) P99(route string) time.Duration { return t.percentile(route, 99) }
+
+// percentile sorts a copy of the route's samples and picks the nearest rank.
+func (t *LatencyTracker) percentile(route string, p int) time.Duration {
+	t.mu.RLock()
+	ring, ok := t.routes[route]
+	if !ok || len(ring.samples) == 0 {
+		t.mu.RUnlock()
+		return 0
+	}
+	samples := slices.Clone(ring.samples)
+	t.mu.RUnlock()
+
+	slices.Sort(samples)
+
+	i := (len(samples)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+
+	return samples[i]
+}
+
+// LatencyMiddleware records how long each request took against its chi route pattern.
+func (s *Server) LatencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+		s.Latency.Record(r.Method+" "+route, time.Since(start))
+	})
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestLatencyTrackerPercentiles(t *testing.T) {
+	tracker := NewLatencyTracker()
+
+	// 1ms to 1000ms in a fixed shuffled order, so the ring is not already sorted
+	for _, i := range rand.New(rand.NewSource(1)).Perm(LatencyWindow) {
+		tracker.Record("GET /today", time.Duration(i+1)*time.Millisecond)
+	}
+
+	tests := []struct {
+		name string
+		got  time.Duration
+		want time.Duration
+	}{
+		{"P50", tracker.P50("GET /today"), 500 * time.Millisecond},
+		{"P95", tracker.P95("GET /today"), 950 * time.Millisecond},
+		{"P99", tracker.P99("GET /today"), 990 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if diff := (tt.got - tt.want).Abs(); diff > tt.want/100 {
+			t.Errorf("%s = %v, want %v ±1%%", tt.name, tt.got, tt.want)
+		}
+	}
+
+	if got := tracker.P50("GET /history"); got != 0 {
+		t.Errorf("P50 of a route without samples = %v, want 0", got)
+	}
+}
+
+func TestLatencyTrackerWindow(t *testing.T) {
+	tracker := NewLatencyTracker()
+
+	for i := 0; i < LatencyWindow; i++ {
+		tracker.Record("GET /today", time.Millisecond)
+	}
+	// a full window of slower requests pushes every earlier sample out
+	for i := 0; i < LatencyWindow; i++ {
+		tracker.Record("GET /today", time.Second)
+	}
+
+	if got := tracker.P50("GET /today"); got != time.Second {
+		t.Errorf("P50 = %v, want %v", got, time.Second)
+	}
+}
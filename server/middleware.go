@@ -0,0 +1,506 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/connorkuljis/food-diary/repo"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	CSRFHeader    = "X-CSRF-Token"
+	CSRFFormField = "_csrf"
+	csrfKey       = "csrf" // session value holding the token
+
+	RememberMeMaxAge = 30 * 24 * 60 * 60 // seconds a "remember me" login lasts
+	rememberKey      = "remember"        // session value set when the user ticked "remember me"
+	sessionIPKey     = "ip"              // session value holding the IP the user signed in from
+	sessionUAKey     = "user_agent"      // session value holding the User-Agent the user signed in with
+
+	ChangePasswordPath = "/settings/change-password" // where AuthMiddleware sends users with an expired password
+)
+
+// ContentSecurityPolicy only lets scripts load from this origin and the CDNs
+// in templates/head.html, so inline scripts and hx-on/js: attributes are
+// blocked. Styles stay inline as the components use scoped <style> blocks.
+const ContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' https://unpkg.com https://cdn.jsdelivr.net; " +
+	"style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data:; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'"
+
+type csrfContextKey struct{}
+
+// SecurityHeadersMiddleware sets the Content-Security-Policy and the other
+// browser hardening headers on every response.
+func SecurityHeadersMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", ContentSecurityPolicy)
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		h.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=(), payment=()")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireBearerToken rejects requests that do not carry "Authorization: Bearer <token>".
+func RequireBearerToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RememberMeMiddleware keeps "remember me" sessions persistent. Sessions are
+// session cookies by default, so without this any handler that saves the
+// session would turn a 30 day cookie back into one that ends with the browser.
+// It relies on the store caching the session for the rest of the request.
+func RememberMeMiddleware(store *sessions.CookieStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session, _ := store.Get(r, SessionName)
+			if remember, _ := session.Values[rememberKey].(bool); remember {
+				session.Options.MaxAge = RememberMeMaxAge
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CSRFMiddleware makes sure every session has a CSRF token and rejects
+// mutating requests that do not echo it back in the X-CSRF-Token header or
+// the _csrf form field. Handlers read the token with CSRFToken.
+func (s *Server) CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, _ := s.Sessions.Get(r, SessionName)
+		token, _ := session.Values[csrfKey].(string)
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			// keep an existing token so forms open in other tabs stay valid
+			if token == "" {
+				var err error
+				token, err = newCSRFToken()
+				if err != nil {
+					s.ServerError(w, r, err)
+					return
+				}
+
+				session.Values[csrfKey] = token
+				if err := session.Save(r, w); err != nil {
+					s.ServerError(w, r, err)
+					return
+				}
+			}
+		default:
+			got := r.Header.Get(CSRFHeader)
+			if got == "" {
+				// parsing the form reads the body, so cap it at the largest
+				// upload any handler accepts before it gets the chance to
+				r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize)
+				got = r.PostFormValue(CSRFFormField)
+			}
+
+			if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				s.Logger.Warn("csrf check failed", "method", r.Method, "path", r.URL.Path)
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), csrfContextKey{}, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newCSRFToken returns a random token for a session.
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CSRFToken returns the token CSRFMiddleware attached to the request, for
+// rendering into forms. It is empty outside of the middleware.
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey{}).(string)
+	return token
+}
+
+// clientIP returns the IP address the request came from, without the port.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// RateLimitMiddleware allows each client IP at most requests within a sliding
+// window. Further requests get 429 Too Many Requests with a Retry-After header.
+func RateLimitMiddleware(requests int, window time.Duration) func(http.Handler) http.Handler {
+	var (
+		mu        sync.Mutex
+		hits      = make(map[string][]time.Time)
+		lastSweep = time.Now()
+	)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(r)
+
+			now := time.Now()
+			cutoff := now.Add(-window)
+
+			mu.Lock()
+			// forget clients that have gone quiet so the map does not grow forever
+			if now.Sub(lastSweep) > window {
+				for key, times := range hits {
+					if times[len(times)-1].Before(cutoff) {
+						delete(hits, key)
+					}
+				}
+				lastSweep = now
+			}
+
+			// drop hits that have slid out of the window
+			times := hits[ip]
+			for len(times) > 0 && !times[0].After(cutoff) {
+				times = times[1:]
+			}
+
+			if len(times) >= requests {
+				retryAfter := times[0].Add(window).Sub(now)
+				hits[ip] = times
+				mu.Unlock()
+
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+
+			hits[ip] = append(times, now)
+			mu.Unlock()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type userIdContextKey struct{}
+
+// AuthMiddleware only lets signed in users through and stores their id in the
+// request context for MustGetUserId. Pages send everyone else to /login, while
+// API calls get 401 Unauthorized as a redirect means nothing to them.
+//
+// With Config.SessionBindIP or Config.SessionBindUA set, a session used from
+// another IP or User-Agent than it was signed in with is treated as stolen: it
+// is cleared and the request is turned away as if signed out.
+//
+// When Config.PasswordMaxAge is set, users whose password is older than that
+// are sent to ChangePasswordPath with a flash, and API calls get 403 Forbidden,
+// until they have changed it.
+func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, err := GetUserId(r, s.Sessions)
+		if err != nil {
+			unauthenticated(w, r, err)
+			return
+		}
+
+		if s.Config.SessionBindIP || s.Config.SessionBindUA {
+			session, _ := s.Sessions.Get(r, SessionName)
+			ip, _ := session.Values[sessionIPKey].(string)
+			ua, _ := session.Values[sessionUAKey].(string)
+
+			if (s.Config.SessionBindIP && ip != clientIP(r)) || (s.Config.SessionBindUA && ua != r.UserAgent()) {
+				s.Logger.Warn("session used from a different client",
+					"user_id", userId,
+					"session_ip", ip,
+					"request_ip", clientIP(r),
+					"session_user_agent", ua,
+					"request_user_agent", r.UserAgent(),
+				)
+
+				for key := range session.Values {
+					delete(session.Values, key)
+				}
+				session.Options.MaxAge = -1
+				if err := session.Save(r, w); err != nil {
+					s.ServerError(w, r, err)
+					return
+				}
+
+				unauthenticated(w, r, errors.New("Session is no longer valid, please sign in again"))
+				return
+			}
+		}
+
+		if s.Config.PasswordMaxAge > 0 && r.URL.Path != ChangePasswordPath && r.URL.Path != "/api/users/password" {
+			// the session may outlive the account
+			user, err := repo.GetUserById(r.Context(), userId)
+			if errors.Is(err, sql.ErrNoRows) {
+				unauthenticated(w, r, err)
+				return
+			}
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+
+			if repo.IsPasswordExpired(user, s.Config.PasswordMaxAge) {
+				if strings.HasPrefix(r.URL.Path, "/api/") {
+					http.Error(w, "Password expired, change it to continue", http.StatusForbidden)
+					return
+				}
+				if err := SetFlash(r, w, s.Sessions, "Your password has expired, please choose a new one.", FlashWarning); err != nil {
+					s.ServerError(w, r, err)
+					return
+				}
+				http.Redirect(w, r, ChangePasswordPath, http.StatusSeeOther)
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), userIdContextKey{}, userId)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// unauthenticated answers a request AuthMiddleware turned away.
+func unauthenticated(w http.ResponseWriter, r *http.Request, err error) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	http.Redirect(w, r, LoginRedirect(r), http.StatusSeeOther)
+}
+
+// AdminMiddleware only lets Config.AdminUserID through and must run after
+// AuthMiddleware. Everyone else gets 403 Forbidden, and so does the admin when
+// no ADMIN_USER_ID is configured.
+func (s *Server) AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Config.AdminUserID == 0 || MustGetUserId(r) != s.Config.AdminUserID {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MustGetUserId returns the id stored by AuthMiddleware. It panics when the
+// middleware was not applied to the route, which is a programming error.
+func MustGetUserId(r *http.Request) int64 {
+	userId, ok := r.Context().Value(userIdContextKey{}).(int64)
+	if !ok {
+		panic("MustGetUserId called on a route without AuthMiddleware")
+	}
+	return userId
+}
+
+// GzipMiddleware compresses responses for clients that accept gzip. Whether a
+// response is compressed is decided when its headers are written, so formats
+// that are already compressed, such as images, are passed through untouched.
+func GzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		// ranges refer to the uncompressed body, so leave those to the file server
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header lists gzip without q=0.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(enc, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// incompressibleTypes are content type prefixes that gain nothing from gzip.
+var incompressibleTypes = []string{
+	"image/",
+	"audio/",
+	"video/",
+	"font/woff",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/pdf",
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	if w.shouldCompress(status) {
+		h := w.Header()
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		// net/http would sniff the compressed bytes, so sniff the original
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipResponseWriter) shouldCompress(status int) bool {
+	if status < http.StatusOK || status == http.StatusNoContent ||
+		status == http.StatusPartialContent || status == http.StatusNotModified {
+		return false
+	}
+
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	// WriteHeader was called before a type was set, which is rare enough to skip
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		return false
+	}
+
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Flush sends anything buffered by gzip before flushing the connection.
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the gzip stream, it is a no-op for uncompressed responses.
+func (w *gzipResponseWriter) Close() error {
+	if w.gz == nil {
+		return nil
+	}
+
+	return w.gz.Close()
+}
+
+// StaticCacheMiddleware lets browsers cache the embedded static files, which
+// only change with a new build. Their URLs stay the same across builds, so
+// caches must revalidate each use; requests carrying the file's current ETag
+// in If-None-Match get a 304 without the file being read.
+func (s *Server) StaticCacheMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag, ok := s.StaticETags[r.URL.Path]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Cache-Control", "public, no-cache")
+		h.Set("ETag", etag)
+
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// etagMatches does the weak comparison If-None-Match calls for, ignoring the W/ prefix.
+func etagMatches(header, etag string) bool {
+	want := strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == want {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/connorkuljis/food-diary/repo"
+)
+
+func TestStaticCacheMiddleware(t *testing.T) {
+	fsys := fstest.MapFS{"static/js/app.js": {Data: []byte("console.log('hi')")}}
+	s, err := NewServerWithOptions(fsys, WithSessionSecret([]byte("test-secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := s.StaticCacheMiddleware(http.FileServer(http.FS(fsys)))
+
+	etag := s.StaticETags["/static/js/app.js"]
+	if etag == "" {
+		t.Fatal("no ETag computed for /static/js/app.js")
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        int
+	}{
+		{"no validator", "", http.StatusOK},
+		{"current etag", etag, http.StatusNotModified},
+		{"strong form of the etag", etag[len("W/"):], http.StatusNotModified},
+		{"one of several", `"stale", ` + etag, http.StatusNotModified},
+		{"stale etag", `W/"stale"`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/static/js/app.js", nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "public, no-cache" {
+				t.Errorf("Cache-Control = %q, want %q", got, "public, no-cache")
+			}
+			if tt.want == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Errorf("304 sent a %d byte body", rec.Body.Len())
+			}
+		})
+	}
+}
+
+// setPasswordAge backdates the user's last password change by age.
+func setPasswordAge(t *testing.T, user repo.User, age time.Duration) {
+	t.Helper()
+
+	changedAt := time.Now().UTC().Add(-age).Format(repo.Timestamp)
+	_, err := repo.DB().Exec(`UPDATE Users SET last_password_change_at = ? WHERE id = ?`, changedAt, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthMiddlewarePasswordExpiry(t *testing.T) {
+	const day = 24 * time.Hour
+
+	tests := []struct {
+		name     string
+		maxAge   time.Duration
+		age      time.Duration
+		path     string
+		want     int
+		location string
+	}{
+		{"disabled", 0, 200 * day, "/today", http.StatusOK, ""},
+		{"not expired", 90 * day, 10 * day, "/today", http.StatusOK, ""},
+		{"expired", 90 * day, 100 * day, "/today", http.StatusSeeOther, ChangePasswordPath},
+		{"expired api", 90 * day, 100 * day, "/api/meals", http.StatusForbidden, ""},
+		{"expired on the change password page", 90 * day, 100 * day, ChangePasswordPath, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.Config.PasswordMaxAge = tt.maxAge
+			user := newTestUser(t, "a@b.com")
+			setPasswordAge(t, user, tt.age)
+
+			rec := serve(s, httptest.NewRequest(http.MethodGet, tt.path, nil), sessionCookie(t, s, user.Id))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestChangeExpiredPassword(t *testing.T) {
+	s := newTestServer(t)
+	s.Config.PasswordMaxAge = 90 * 24 * time.Hour
+	user := newTestUser(t, "a@b.com")
+	setPasswordAge(t, user, 100*24*time.Hour)
+
+	// the redirect carries the flash, which the change password page shows
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/today", nil), sessionCookie(t, s, user.Id))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+	rec = serve(s, httptest.NewRequest(http.MethodGet, ChangePasswordPath, nil), rec.Result().Cookies()[0])
+	if !strings.Contains(rec.Body.String(), "Your password has expired") {
+		t.Error("change password page does not show the expiry flash")
+	}
+
+	form := url.Values{"current_password": {testPassword}, "new_password": {"a-new-password"}}
+	rec = serve(s, postForm(ChangePasswordPath, form), sessionCookie(t, s, user.Id))
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/today" {
+		t.Fatalf("change password = %d %q, want %d /today", rec.Code, rec.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/today", nil), sessionCookie(t, s, user.Id))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status after changing the password = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestChangePasswordPageWrongPassword(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+
+	form := url.Values{"current_password": {"not-my-password"}, "new_password": {"a-new-password"}}
+	rec := serve(s, postForm(ChangePasswordPath, form), sessionCookie(t, s, user.Id))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !strings.Contains(rec.Body.String(), errWrongPassword.Error()) {
+		t.Error("page does not say the current password is incorrect")
+	}
+}
+
+func TestAuthMiddlewareSessionBinding(t *testing.T) {
+	tests := []struct {
+		name         string
+		bindIP       bool
+		bindUA       bool
+		remoteAddr   string
+		userAgent    string
+		wantSignedIn bool
+	}{
+		{"binding disabled, IP changed", false, false, "198.51.100.7:4321", "agent-a", true},
+		{"binding disabled, User-Agent changed", false, false, "192.0.2.1:1234", "agent-b", true},
+		{"IP bound, same client", true, false, "192.0.2.1:5678", "agent-a", true},
+		{"IP bound, IP changed", true, false, "198.51.100.7:4321", "agent-a", false},
+		{"IP bound, User-Agent changed", true, false, "192.0.2.1:1234", "agent-b", true},
+		{"User-Agent bound, User-Agent changed", false, true, "192.0.2.1:1234", "agent-b", false},
+		{"User-Agent bound, IP changed", false, true, "198.51.100.7:4321", "agent-a", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.Config.SessionBindIP = tt.bindIP
+			s.Config.SessionBindUA = tt.bindUA
+			newTestUser(t, "a@b.com")
+
+			// sign in from 192.0.2.1 with agent-a
+			login := postForm("/login", url.Values{"email": {"a@b.com"}, "password": {testPassword}})
+			login.RemoteAddr = "192.0.2.1:1234"
+			login.Header.Set("User-Agent", "agent-a")
+			rec := serve(s, login, sessionCookie(t, s, 0))
+			if rec.Code != http.StatusSeeOther {
+				t.Fatalf("login status = %d, want %d", rec.Code, http.StatusSeeOther)
+			}
+			cookie := rec.Result().Cookies()[0]
+
+			req := httptest.NewRequest(http.MethodGet, "/today", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("User-Agent", tt.userAgent)
+			rec = serve(s, req, cookie)
+
+			if tt.wantSignedIn {
+				if rec.Code != http.StatusOK {
+					t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+				}
+				return
+			}
+
+			if rec.Code != http.StatusSeeOther || !strings.HasPrefix(rec.Header().Get("Location"), "/login") {
+				t.Fatalf("got %d %q, want a redirect to /login", rec.Code, rec.Header().Get("Location"))
+			}
+			cleared := rec.Result().Cookies()
+			if len(cleared) == 0 || cleared[0].MaxAge >= 0 {
+				t.Error("the session cookie was not cleared")
+			}
+		})
+	}
+}
+
+func TestRateLimitLogin(t *testing.T) {
+	s := newTestServer(t)
+	newTestUser(t, "a@b.com")
+	cookie := sessionCookie(t, s, 0)
+
+	login := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := postForm("/login", url.Values{"email": {"a@b.com"}, "password": {"wrong"}})
+		req.RemoteAddr = remoteAddr
+		return serve(s, req, cookie)
+	}
+
+	for i := 0; i < AuthRateLimit; i++ {
+		if rec := login("192.0.2.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("attempt %d status = %d, want %d", i+1, rec.Code, http.StatusOK)
+		}
+	}
+
+	rec := login("192.0.2.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("attempt %d status = %d, want %d", AuthRateLimit+1, rec.Code, http.StatusTooManyRequests)
+	}
+	retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 || retryAfter > 60 {
+		t.Errorf("Retry-After = %q, want 1 to 60 seconds", rec.Header().Get("Retry-After"))
+	}
+
+	if rec := login("198.51.100.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("another client's status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"log/slog"
+
+	"github.com/connorkuljis/food-diary/repo"
+)
+
+// ServerOption configures a Server built with NewServerWithOptions.
+type ServerOption func(*serverOptions)
+
+type serverOptions struct {
+	config    Config
+	siteTitle string
+	logger    *slog.Logger
+	meals     repo.MealRepository
+}
+
+// WithConfig starts from cfg, options after it override its fields.
+func WithConfig(cfg Config) ServerOption {
+	return func(o *serverOptions) {
+		o.config = cfg
+	}
+}
+
+func WithPort(port string) ServerOption {
+	return func(o *serverOptions) {
+		o.config.Port = port
+	}
+}
+
+// WithSessionSecret sets the key used to sign session cookies.
+func WithSessionSecret(secret []byte) ServerOption {
+	return func(o *serverOptions) {
+		o.config.SessionSecret = string(secret)
+	}
+}
+
+// WithSiteTitle sets the title shown in the page header and <title>.
+func WithSiteTitle(title string) ServerOption {
+	return func(o *serverOptions) {
+		o.siteTitle = title
+	}
+}
+
+func WithLogger(l *slog.Logger) ServerOption {
+	return func(o *serverOptions) {
+		o.logger = l
+	}
+}
+
+// WithMealRepository sets where meals are stored. Without it Server.Meals must
+// be set before calling Routes.
+func WithMealRepository(meals repo.MealRepository) ServerOption {
+	return func(o *serverOptions) {
+		o.meals = meals
+	}
+}
@@ -1,16 +1,30 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
 	"database/sql"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/fs"
-	"log"
+	"log/slog"
+	"mime/multipart"
 	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
 	"text/template"
 	"time"
+	"unicode/utf8"
 
 	"github.com/connorkuljis/food-diary/repo"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/gorilla/sessions"
 	"golang.org/x/crypto/bcrypt"
 	"modernc.org/sqlite"
@@ -23,40 +37,114 @@ type Server struct {
 	Router     *chi.Mux
 	Sessions   *sessions.CookieStore
 	SiteData   SiteData
+	Latency    *LatencyTracker
+	Config     Config
+	Meals      repo.MealRepository // set once the database is open
+	Foods      repo.FoodLookup     // suggests meal names, see /api/food/search
+	Logger     *slog.Logger
 
-	Port         string
-	StaticDir    string // location of static assets
-	TemplatesDir string // location of html templates, makes template parsing less verbose.
+	StaticDir    string            // location of static assets
+	StaticETags  map[string]string // URL path to ETag, the embedded files never change at runtime
+	TemplatesDir string            // location of html templates, makes template parsing less verbose.
 }
 
 type SiteData struct {
-	Title string
+	Title        string
+	PrimaryColor string // hex colour used for generated assets such as the favicon
 }
 
 const (
 	Port             = "8080"
 	StaticDirName    = "/static"
 	TemplatesDirName = "/templates"
+	UploadDirName    = "uploads"
+	MaxUploadSize    = 10 << 20 // 10 MB, in-memory limit for multipart forms
+	MaxBatchSize     = 50       // most meals accepted by a single batch request
+	SummaryLimit     = 30       // default number of days returned by the summary endpoint
+	MinPurgeAge      = 30       // days, bulk purges cannot reach more recent meals
+	DefaultPerPage   = 20       // meals per history page
+	MaxPerPage       = 100
+	AuthRateLimit    = 5 // login or register attempts per client per minute
+	MinSearchLength  = 2
+	SearchLimit      = 50
+	FrequentLimit    = 10  // default number of foods returned by the frequent meals endpoint
+	TodayFrequent    = 5   // frequent foods shown on the today page
+	MaxSeriesDays    = 366 // longest range the meals per day endpoint will fill in
 )
 
-func NewServer(fs fs.FS) *Server {
+var ErrMissingSessionSecret = errors.New("SESSION_SECRET must be set when not running in dev mode")
+
+// NewServer builds a Server from cfg. It is kept for existing callers, new
+// code should use NewServerWithOptions.
+func NewServer(fs fs.FS, cfg Config) (*Server, error) {
+	return NewServerWithOptions(fs, WithConfig(cfg))
+}
+
+// NewServerWithOptions builds a Server serving templates and static files from
+// fs. Without options it listens on Port with a default title, logs to stderr
+// at Config.LogLevel, and requires a session secret.
+func NewServerWithOptions(fs fs.FS, opts ...ServerOption) (*Server, error) {
+	o := serverOptions{
+		config:    Config{Port: Port, UploadDir: UploadDirName},
+		siteTitle: "Food Diary",
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cfg := o.config
+	if o.logger == nil {
+		o.logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel}))
+	}
+	secret := []byte(cfg.SessionSecret)
+	if len(secret) == 0 {
+		if !cfg.DevMode {
+			return nil, ErrMissingSessionSecret
+		}
+
+		// sessions will not survive a restart, which is fine for development
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		o.logger.Warn("SESSION_SECRET not set, using a random session key")
+	}
+
 	router := chi.NewMux()
-	store := sessions.NewCookieStore([]byte("3lWcaN9nYFjh9Dy5RJWXR84nxYSOZSQx4R11y8NxUNQ="))
-	siteData := SiteData{Title: "Food Diary"}
+	store := sessions.NewCookieStore(secret)
+	// session cookies unless the user asks to be remembered, cookies are still
+	// only accepted for the store's default 30 days
+	store.Options.MaxAge = 0
+	siteData := SiteData{Title: o.siteTitle, PrimaryColor: "#4caf50"}
+
+	etags, err := staticETags(fs, strings.TrimPrefix(StaticDirName, "/"))
+	if err != nil {
+		return nil, err
+	}
 
 	return &Server{
 		FileSystem:   fs,
 		Router:       router,
 		Sessions:     store,
-		Port:         Port,
 		StaticDir:    StaticDirName,
+		StaticETags:  etags,
 		TemplatesDir: TemplatesDirName,
 		SiteData:     siteData,
-	}
+		Latency:      NewLatencyTracker(),
+		Config:       cfg,
+		Meals:        o.meals,
+		Foods:        repo.StaticFoodLookup{},
+		Logger:       o.logger,
+	}, nil
+}
+
+// ServeHTTP lets the Server be used directly as an http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Router.ServeHTTP(w, r)
 }
 
 // This function automatically builds *template.Templates using filenames and
-func (s *Server) CompileTemplates(name string, files []HTMLFile, funcMap template.FuncMap) *template.Template {
+func (s *Server) CompileTemplates(name string, files []HTMLFile, funcMap template.FuncMap) (*template.Template, error) {
 	// give the template a name
 	tmpl := template.New(name)
 
@@ -74,124 +162,412 @@ func (s *Server) CompileTemplates(name string, files []HTMLFile, funcMap templat
 	// build the template
 	tmpl, err := tmpl.ParseFS(s.FileSystem, patterns...)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("compiling %s: %w", name, err)
+	}
+
+	return tmpl, nil
+}
+
+// PreloadTemplates compiles every view up front so that a broken template is
+// reported at start up rather than on the first request that renders it.
+func (s *Server) PreloadTemplates() error {
+	for name, view := range Views {
+		if _, err := s.CompileTemplates(name, view, nil); err != nil {
+			return err
+		}
 	}
 
-	return tmpl
+	return nil
+}
+
+// CheckTemplates compiles every view in fsys without building a Server, so it
+// needs neither a session secret nor a database.
+func CheckTemplates(fsys fs.FS) error {
+	return (&Server{FileSystem: fsys}).PreloadTemplates()
+}
+
+// errorHandler answers every request with err. Handlers fall back to it when
+// their templates fail to compile, which PreloadTemplates guards against.
+func (s *Server) errorHandler(err error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.ServerError(w, r, err)
+	}
 }
 
+// Routes registers every handler on the router. Server.Meals must be set
+// first, as the handlers depend on it.
 func (s *Server) Routes() {
-	s.Router.Handle("/static/*", http.FileServer(http.FS(s.FileSystem)))
-	s.Router.HandleFunc("/", s.handleIndex())
+	if s.Meals == nil {
+		panic("server: Routes called before Server.Meals was set")
+	}
+
+	s.Router.Use(middleware.RequestID)
+	s.Router.Use(middleware.Logger)
+	s.Router.Use(middleware.Recoverer)
+	s.Router.Use(s.LatencyMiddleware)
+	s.Router.Use(SecurityHeadersMiddleware)
+	s.Router.Use(GzipMiddleware)
+
+	s.Router.NotFound(s.handleNotFound())
+	s.Router.MethodNotAllowed(s.handleMethodNotAllowed())
 
-	// Template rendering
-	s.Router.HandleFunc("/today", s.handleToday(TodayView))
-	s.Router.HandleFunc("/login", s.handleLogin(LoginView))
-	s.Router.HandleFunc("/register", s.handleRegister(RegisterView))
-	s.Router.HandleFunc("/history", s.handleHistory(HistoryView))
+	s.Router.With(s.StaticCacheMiddleware).Handle("/static/*", http.FileServer(http.FS(s.FileSystem)))
+	s.Router.HandleFunc("/favicon.ico", s.handleFavicon())
+	s.Router.Get("/health", s.handleHealthCheck())
 
-	// HTMX 'n AJAX
-	s.Router.HandleFunc("/logout", s.handleLogout())
-	s.Router.Post("/api/meals", s.handleMeals())
-	s.Router.Delete("/api/meals/{id}", s.handleDeleteMeal())
+	// everything that can change state checks the CSRF token, except the
+	// static file server and health check above
+	s.Router.Group(func(r chi.Router) {
+		r.Use(RememberMeMiddleware(s.Sessions))
+		r.Use(s.CSRFMiddleware)
+
+		r.HandleFunc("/", s.handleIndex())
+
+		// Template rendering
+		login := s.handleLogin(LoginView)
+		r.Get("/login", login)
+		r.With(RateLimitMiddleware(AuthRateLimit, time.Minute)).Post("/login", login)
+		register := s.handleRegister(RegisterView)
+		r.Get("/register", register)
+		r.With(RateLimitMiddleware(AuthRateLimit, time.Minute)).Post("/register", register)
+
+		// HTMX 'n AJAX
+		r.HandleFunc("/logout", s.handleLogout())
+
+		// Signed in only, handlers read the user with MustGetUserId
+		r.Group(func(r chi.Router) {
+			r.Use(s.AuthMiddleware)
+
+			r.HandleFunc("/today", s.handleToday(TodayView))
+			r.HandleFunc("/history", s.handleHistory(HistoryView))
+			r.HandleFunc("/weekly", s.handleWeekly(WeeklyView))
+			r.HandleFunc("/monthly", s.handleMonthly(MonthlyView))
+			r.HandleFunc(ChangePasswordPath, s.handleChangePasswordPage(ChangePasswordView))
+
+			r.Get("/api/meals", s.handleGetMeals())
+			r.Get("/api/meals/{id}", s.handleGetMeal())
+			r.Post("/api/meals", s.handleMeals())
+			r.Post("/api/meals/batch", s.handleMealBatch())
+			r.Post("/api/meals/copy", s.handleCopyMeals())
+			r.Get("/api/meals/count", s.handleMealCount())
+			r.Get("/api/meals/summary", s.handleMealSummary())
+			r.Get("/api/meals/search", s.handleSearchMeals())
+			r.Get("/api/meals/dates", s.handleMealDates())
+			r.Get("/api/food/search", s.handleFoodSearch())
+			r.Get("/api/meals/export.csv", s.handleExportCSV())
+			r.Get("/api/meals/export.json", s.handleExportJSON())
+			r.Post("/api/meals/import", s.handleImportCSV())
+			r.Patch("/api/meals/{id}", s.handleUpdateMeal())
+			r.Post("/api/meals/{id}/photo", s.handleUploadMealPhoto())
+			r.Post("/api/meals/{id}/duplicate", s.handleDuplicateMeal())
+			r.Delete("/api/meals/{id}", s.handleDeleteMeal())
+			r.Delete("/api/meals", s.handleDeleteMeals())
+			r.Get("/uploads/*", s.handleUploads())
+
+			// Stats
+			r.Get("/api/stats/calories", s.handleCalorieStats())
+			r.Get("/api/stats/frequent-meals", s.handleFrequentMeals())
+			r.Get("/api/stats/meals-per-day", s.handleMealsPerDay())
+			r.Get("/api/stats/day-of-week", s.handleDayOfWeekStats())
+			r.Get("/api/stats/meal-type-counts", s.handleMealTypeCounts())
+			r.Get("/api/stats/streak", s.handleStreak())
+			r.Get("/api/stats/summary", s.handleStatsSummary())
+
+			// Users
+			r.Get("/api/users/me", s.handleGetMe())
+			r.Put("/api/users/password", s.handleChangePassword())
+			r.Delete("/api/users/me", s.handleDeleteAccount())
+
+			// Feeds
+			r.Get("/feed/meals.atom", s.handleMealsFeed())
+		})
+
+		// Metrics, only for ADMIN_USER_ID
+		r.With(s.AuthMiddleware, s.AdminMiddleware).Get("/admin/latency", s.handleLatency())
+		r.With(s.AuthMiddleware, s.AdminMiddleware).Get("/admin/users/search", s.handleAdminSearchUsers())
+
+		// Admin, only for ADMIN_USER_ID
+		r.Route("/api/admin", func(r chi.Router) {
+			r.Use(s.AuthMiddleware, s.AdminMiddleware)
+
+			r.Get("/stats", s.handleAdminStats())
+			r.Post("/vacuum", s.handleAdminVacuum())
+			r.Get("/active-users", s.handleAdminActiveUsers())
+		})
+
+		// Profiling, only available in development
+		if s.Config.DevMode {
+			r.Route("/debug", func(r chi.Router) {
+				if s.Config.PprofToken != "" {
+					r.Use(RequireBearerToken(s.Config.PprofToken))
+				}
+				r.Mount("/", middleware.Profiler())
+			})
+		}
+	})
 }
 
-func ServerError(w http.ResponseWriter, err error) {
-	log.Print(err)
+// ServerError logs err with the request it failed and responds with a 500, or
+// a 503 when the database timed out.
+func (s *Server) ServerError(w http.ResponseWriter, r *http.Request, err error) {
+	// tag the error with the request id so it can be matched to the access log
+	s.Logger.Error("server error",
+		"error", err,
+		"request_id", middleware.GetReqID(r.Context()),
+		"method", r.Method,
+		"path", r.URL.Path,
+	)
+
+	// a query that ran past Config.QueryTimeout is a temporary condition
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "The database took too long to respond, please try again.", http.StatusServiceUnavailable)
+		return
+	}
+
 	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
 
+// popFlash consumes the pending flash message for the page being rendered.
+// Failures are only logged as a lost notice should not fail the page.
+func (s *Server) popFlash(w http.ResponseWriter, r *http.Request) FlashData {
+	msg, category, err := GetFlash(r, s.Sessions)
+	if err != nil {
+		s.Logger.Warn("could not read flash message", "error", err)
+		return FlashData{}
+	}
+
+	if msg == "" {
+		return FlashData{}
+	}
+
+	// persist the removal so the notice is not shown again
+	if err := sessions.Save(r, w); err != nil {
+		s.Logger.Warn("could not clear flash message", "error", err)
+	}
+
+	return FlashData{Message: msg, Category: category}
+}
+
+// handleIndex sends signed in users to today and everyone else to the login page.
 func (s *Server) handleIndex() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := GetUserId(r, s.Sessions); err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
 		http.Redirect(w, r, "/today", http.StatusSeeOther)
 	}
 }
 
+func (s *Server) handleNotFound() http.HandlerFunc {
+	return s.handleErrorPage(ErrorView, http.StatusNotFound)
+}
+
+func (s *Server) handleMethodNotAllowed() http.HandlerFunc {
+	return s.handleErrorPage(ErrorView, http.StatusMethodNotAllowed)
+}
+
+// handleErrorPage renders the site layout with the given error status.
+func (s *Server) handleErrorPage(view []HTMLFile, code int) http.HandlerFunc {
+	type ViewData struct {
+		SiteData  SiteData
+		Flash     FlashData
+		CSRFToken string
+		Code      int
+		Message   string
+	}
+
+	tmpl, err := s.CompileTemplates("error.html", view, nil)
+	if err != nil {
+		return s.errorHandler(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := ViewData{
+			SiteData:  s.SiteData,
+			CSRFToken: CSRFToken(r),
+			Code:      code,
+			Message:   http.StatusText(code),
+		}
+		data.SiteData.Title += " | " + data.Message
+
+		if err := RenderTemplateStatus(w, code, tmpl, "root", data); err != nil {
+			s.ServerError(w, r, err)
+		}
+	}
+}
+
+func (s *Server) handleFavicon() http.HandlerFunc {
+	// the favicon never changes at runtime so it is built once
+	favicon, err := buildFavicon(s.SiteData.PrimaryColor)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "public, max-age=2592000") // 30 days
+		w.Write(favicon)
+	}
+}
+
 func (s *Server) handleToday(view []HTMLFile) http.HandlerFunc {
 	type ViewData struct {
-		SiteData SiteData
-		Meals    []repo.Meal
+		SiteData        SiteData
+		Flash           FlashData
+		CSRFToken       string
+		Meals           []repo.Meal
+		MealTypeCounts  map[repo.MealType]int
+		CurrentStreak   int
+		FrequentMeals   []repo.FrequentMeal
+		DayOfWeek       []DayOfWeekBar
+		Sections        []MealSection
+		DefaultMealType repo.MealType
 	}
 
-	tmpl := s.CompileTemplates("today.html", view, nil)
+	tmpl, err := s.CompileTemplates("today.html", view, nil)
+	if err != nil {
+		return s.errorHandler(err)
+	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		// get the user id from the cookie
-		// if user id not found in cookie, they are send to the login page
-		userId, err := GetUserId(r, s.Sessions)
+		userId := MustGetUserId(r)
+
+		meals, err := s.Meals.GetMealsByUserAndDateSortedByType(r.Context(), repo.User{Id: userId}, time.Now())
 		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			s.ServerError(w, r, err)
 			return
 		}
 
-		meals, err := repo.GetMealsByUserAndDate(repo.User{Id: userId}, time.Now())
+		counts, err := s.Meals.GetMealCountByTypeForUser(r.Context(), repo.User{Id: userId})
 		if err != nil {
-			ServerError(w, err)
+			s.ServerError(w, r, err)
 			return
 		}
 
-		tmpl.ExecuteTemplate(w, "root", ViewData{
-			SiteData: s.SiteData,
-			Meals:    meals,
-		})
+		streak, err := s.Meals.GetMealStreak(r.Context(), repo.User{Id: userId})
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		frequent, err := s.Meals.GetMostFrequentMeals(r.Context(), repo.User{Id: userId}, TodayFrequent)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		averages, err := s.Meals.GetCalorieAverageByDayOfWeek(r.Context(), repo.User{Id: userId})
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		data := ViewData{
+			SiteData:        s.SiteData,
+			Flash:           s.popFlash(w, r),
+			CSRFToken:       CSRFToken(r),
+			Meals:           meals,
+			MealTypeCounts:  counts,
+			CurrentStreak:   streak,
+			FrequentMeals:   frequent,
+			DayOfWeek:       dayOfWeekBars(averages),
+			Sections:        mealSections(meals),
+			DefaultMealType: defaultMealType(frequent),
+		}
+		if err := RenderTemplate(w, tmpl, "root", data); err != nil {
+			s.ServerError(w, r, err)
+		}
 	}
 }
 
 func (s *Server) handleLogin(view []HTMLFile) http.HandlerFunc {
 	type ViewData struct {
 		SiteData     SiteData
+		Flash        FlashData
+		CSRFToken    string
 		ErrorMessage string
+		Redirect     string
 	}
 
-	tmpl := s.CompileTemplates("login.html", view, nil)
+	tmpl, err := s.CompileTemplates("login.html", view, nil)
+	if err != nil {
+		return s.errorHandler(err)
+	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		data := ViewData{
-			SiteData: s.SiteData,
+			SiteData:  s.SiteData,
+			CSRFToken: CSRFToken(r),
+			Redirect:  SafeRedirect(r.FormValue("redirect"), "/today"),
 		}
 		data.SiteData.Title = data.SiteData.Title + " | Login"
 
 		if r.Method == "GET" {
-			tmpl.ExecuteTemplate(w, "root", data)
+			if err := RenderTemplate(w, tmpl, "root", data); err != nil {
+				s.ServerError(w, r, err)
+			}
 		}
 
 		if r.Method == "POST" {
 			// generate a cookie
-			session, _ := s.Sessions.Get(r, "session")
+			session, _ := s.Sessions.Get(r, SessionName)
 
 			// handle the form
 			r.ParseForm()
-			emailStr := r.Form.Get("email")
+			emailStr := strings.TrimSpace(r.Form.Get("email"))
 			passwordStr := r.Form.Get("password")
 
 			// look up the user by email
-			user, err := repo.GetUserByEmail(emailStr)
+			user, err := repo.GetUserByEmail(r.Context(), emailStr)
 			if err != nil {
-				log.Print(err)
+				s.Logger.Info("login failed", "error", err)
 				data.ErrorMessage = "Invalid email or password"
-				tmpl.ExecuteTemplate(w, "root", data)
+				if err := RenderTemplate(w, tmpl, "root", data); err != nil {
+					s.ServerError(w, r, err)
+				}
 				return
 			}
 
 			// compare the hashed passwords
 			err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(passwordStr))
 			if err != nil {
-				log.Print(err)
+				s.Logger.Info("login failed", "user_id", user.Id, "error", err)
 				data.ErrorMessage = "Invalid email or password"
-				tmpl.ExecuteTemplate(w, "root", data)
+				if err := RenderTemplate(w, tmpl, "root", data); err != nil {
+					s.ServerError(w, r, err)
+				}
+				return
+			}
+
+			// start a new session with a new CSRF token, so that nothing
+			// planted in the session before signing in carries over
+			if err := resetSession(session, r); err != nil {
+				s.ServerError(w, r, err)
 				return
 			}
 
-			// save the user id to the cookie
-			session.Values["userId"] = user.Id
+			// save the user id to the cookie, which outlives the browser
+			// session only when asked to
+			session.Values[userIdKey] = user.Id
+			if r.Form.Get("remember") == "true" {
+				session.Values[rememberKey] = true
+				session.Options.MaxAge = RememberMeMaxAge
+			} else {
+				delete(session.Values, rememberKey)
+				session.Options.MaxAge = 0
+			}
 			err = sessions.Save(r, w)
 			if err != nil {
-				ServerError(w, err)
+				s.ServerError(w, r, err)
 				return
 			}
 
-			// send the user to today
-			http.Redirect(w, r, "/today", http.StatusSeeOther)
+			// send the user to where they were going, or today
+			http.Redirect(w, r, data.Redirect, http.StatusSeeOther)
 		}
 	}
 }
@@ -199,203 +575,2163 @@ func (s *Server) handleLogin(view []HTMLFile) http.HandlerFunc {
 func (s *Server) handleRegister(view []HTMLFile) http.HandlerFunc {
 	type ViewData struct {
 		SiteData     SiteData
+		Flash        FlashData
+		CSRFToken    string
 		ErrorMessage string
+		Redirect     string
 	}
 
-	tmpl := s.CompileTemplates("register.html", view, nil)
+	tmpl, err := s.CompileTemplates("register.html", view, nil)
+	if err != nil {
+		return s.errorHandler(err)
+	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		data := ViewData{SiteData: s.SiteData}
+		data := ViewData{
+			SiteData:  s.SiteData,
+			CSRFToken: CSRFToken(r),
+			Redirect:  SafeRedirect(r.FormValue("redirect"), "/today"),
+		}
 
 		// update the site title
 		data.SiteData.Title += " | Register"
 
 		if r.Method == "GET" {
-			tmpl.ExecuteTemplate(w, "root", data)
+			if err := RenderTemplate(w, tmpl, "root", data); err != nil {
+				s.ServerError(w, r, err)
+			}
 		}
 
 		if r.Method == "POST" {
 			// generate a cookie
-			session, _ := s.Sessions.Get(r, "session")
+			session, _ := s.Sessions.Get(r, SessionName)
 
 			// handle the form
 			r.ParseForm()
-			emailStr := r.Form.Get("email")
+			emailStr := strings.TrimSpace(r.Form.Get("email"))
 			passwordStr := r.Form.Get("password")
 
+			if !isValidEmail(emailStr) {
+				data.ErrorMessage = "Please enter a valid email address"
+				if err := RenderTemplateStatus(w, http.StatusBadRequest, tmpl, "root", data); err != nil {
+					s.ServerError(w, r, err)
+				}
+				return
+			}
+
+			if err := validatePassword(passwordStr); err != nil {
+				data.ErrorMessage = err.Error()
+				if err := RenderTemplateStatus(w, http.StatusBadRequest, tmpl, "root", data); err != nil {
+					s.ServerError(w, r, err)
+				}
+				return
+			}
+
 			// hash the password
 			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(passwordStr), 10)
 			if err != nil {
-				ServerError(w, err)
+				s.ServerError(w, r, err)
 				return
 			}
 
 			// create the user information and insert it into the db
-			user, err := repo.InsertUser(repo.NewUser(emailStr, string(hashedPassword)))
+			user, err := repo.InsertUser(r.Context(), repo.NewUser(emailStr, string(hashedPassword)))
 			if err != nil {
 				// we do not want duplicate email registrations
 				if errors.Is(err, sql.ErrNoRows) {
-					ServerError(w, err)
+					s.ServerError(w, r, err)
 					return
 				}
 				if liteErr, ok := err.(*sqlite.Error); ok {
 					code := liteErr.Code()
 					if code == 2067 {
 						data.ErrorMessage = "Invalid email or password."
-						tmpl.ExecuteTemplate(w, "root", data)
+						if err := RenderTemplate(w, tmpl, "root", data); err != nil {
+							s.ServerError(w, r, err)
+						}
+						return
 					}
 				}
+				s.ServerError(w, r, err)
+				return
+			}
+
+			// as with logging in, the signed in session starts afresh
+			if err := resetSession(session, r); err != nil {
+				s.ServerError(w, r, err)
+				return
 			}
 
 			// save user id into the cookie
-			session.Values["userId"] = user.Id
+			session.Values[userIdKey] = user.Id
 			err = sessions.Save(r, w)
 			if err != nil {
-				ServerError(w, err)
+				s.ServerError(w, r, err)
 				return
 			}
 
-			// redirect user to today
-			http.Redirect(w, r, "/today", http.StatusSeeOther)
+			// send the user to where they were going, or today
+			http.Redirect(w, r, data.Redirect, http.StatusSeeOther)
 		}
 	}
 }
 
 func (s *Server) handleHistory(view []HTMLFile) http.HandlerFunc {
 	type ViewData struct {
-		SiteData SiteData
-		Meals    []repo.Meal
+		SiteData       SiteData
+		Flash          FlashData
+		CSRFToken      string
+		Meals          []repo.Meal
+		MealTypeCounts map[repo.MealType]int
+
+		// pagination, only used when browsing all meals
+		TotalCount int
+		Page       int
+		PerPage    int
+		TotalPages int
+		PrevPage   int // 0 when on the first page
+		NextPage   int // 0 when on the last page
 	}
 
-	tmpl := s.CompileTemplates("index.html", view, nil)
+	tmpl, err := s.CompileTemplates("history.html", view, nil)
+	if err != nil {
+		return s.errorHandler(err)
+	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		// get the user id from the cookie
-		userId, err := GetUserId(r, s.Sessions)
-		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusUnauthorized)
-			return
+		userId := MustGetUserId(r)
+		var err error
+
+		data := ViewData{
+			SiteData:   s.SiteData,
+			CSRFToken:  CSRFToken(r),
+			Page:       1,
+			TotalPages: 1,
 		}
 
 		var meals []repo.Meal
-		// get the date query parameter
+		// get the date query parameters
 		dateStr := r.URL.Query().Get("date")
+		fromStr := r.URL.Query().Get("from")
+		toStr := r.URL.Query().Get("to")
+		typeStr := r.URL.Query().Get("meal_type")
 
-		if dateStr != "" {
+		switch {
+		case dateStr != "":
 			// parse the date
-			date, err := time.Parse("2006-01-02", dateStr)
+			date, err := time.Parse(repo.DateFormat, dateStr)
 			if err != nil {
 				http.Error(w, "Invalid date format", http.StatusBadRequest)
 				return
 			}
-			meals, err = repo.GetMealsByUserAndDate(repo.User{Id: userId}, date)
+			meals, err = s.Meals.GetMealsByUserAndDate(r.Context(), repo.User{Id: userId}, date)
 			if err != nil {
-				ServerError(w, err)
+				s.ServerError(w, r, err)
 				return
 			}
-		} else {
-			var err error
-			meals, err = repo.GetAllMeals()
+		case fromStr != "" || toStr != "":
+			if fromStr == "" || toStr == "" {
+				http.Error(w, "Both from and to are required for a date range", http.StatusBadRequest)
+				return
+			}
+
+			from, err := time.Parse(repo.DateFormat, fromStr)
 			if err != nil {
-				ServerError(w, err)
+				http.Error(w, "Invalid from date format", http.StatusBadRequest)
 				return
 			}
-		}
 
-		tmpl.ExecuteTemplate(w, "root", ViewData{
-			SiteData: s.SiteData,
-			Meals:    meals,
-		})
-	}
-}
+			to, err := time.Parse(repo.DateFormat, toStr)
+			if err != nil {
+				http.Error(w, "Invalid to date format", http.StatusBadRequest)
+				return
+			}
 
-// this is called by HTMX
-func (s *Server) handleLogout() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "POST" {
-			// delete the session by removing the user id from session values
-			session, _ := s.Sessions.Get(r, "session")
-			delete(session.Values, "userId")
-			err := sessions.Save(r, w)
+			if to.Before(from) {
+				http.Error(w, "from must not be after to", http.StatusBadRequest)
+				return
+			}
+
+			meals, err = s.Meals.GetMealsByUserAndDateRange(r.Context(), repo.User{Id: userId}, from, to)
 			if err != nil {
-				ServerError(w, err)
+				s.ServerError(w, r, err)
 				return
 			}
-			// send the user back to login
-			w.Header().Add("HX-Redirect", "/login")
+		case typeStr != "":
+			mealType := repo.MealType(typeStr)
+			if !repo.IsValidMealType(mealType) {
+				http.Error(w, "Unknown meal type", http.StatusBadRequest)
+				return
+			}
+
+			meals, err = s.Meals.GetMealsByUserAndType(r.Context(), repo.User{Id: userId}, mealType)
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+		default:
+			page, err := PositiveIntParam(r, "page", 1)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			perPage, err := PositiveIntParam(r, "per_page", DefaultPerPage)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			perPage = min(perPage, MaxPerPage)
+
+			var total int
+			meals, total, err = s.Meals.GetMealsByUserPaginated(r.Context(), repo.User{Id: userId}, perPage, (page-1)*perPage)
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+
+			data.TotalCount = total
+			data.Page = page
+			data.PerPage = perPage
+			data.TotalPages = max(1, (total+perPage-1)/perPage)
+			if page > 1 {
+				data.PrevPage = min(page-1, data.TotalPages)
+			}
+			if page < data.TotalPages {
+				data.NextPage = page + 1
+			}
+		}
+
+		data.MealTypeCounts, err = s.Meals.GetMealCountByTypeForUser(r.Context(), repo.User{Id: userId})
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		data.Flash = s.popFlash(w, r)
+		data.Meals = meals
+		if err := RenderTemplate(w, tmpl, "root", data); err != nil {
+			s.ServerError(w, r, err)
 		}
 	}
 }
 
-func (s *Server) handleMeals() http.HandlerFunc {
-	type FormData struct {
-		Name     string
-		MealType repo.MealType
+func (s *Server) handleWeekly(view []HTMLFile) http.HandlerFunc {
+	type Day struct {
+		Date  string
+		Meals []repo.Meal
+	}
+
+	type ViewData struct {
+		SiteData  SiteData
+		Flash     FlashData
+		CSRFToken string
+		WeekStart string
+		PrevWeek  string
+		NextWeek  string
+		Days      []Day
+	}
+
+	tmpl, err := s.CompileTemplates("weekly.html", view, nil)
+	if err != nil {
+		return s.errorHandler(err)
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		userId, err := GetUserId(r, s.Sessions)
-		if err != nil {
-			ServerError(w, err)
-			return
+		userId := MustGetUserId(r)
+		var err error
+
+		// any day in the week can be given, it is moved back to the Monday
+		day := time.Now()
+		if weekStr := r.URL.Query().Get("week"); weekStr != "" {
+			day, err = time.ParseInLocation(repo.DateFormat, weekStr, time.Local)
+			if err != nil {
+				http.Error(w, "Invalid week format", http.StatusBadRequest)
+				return
+			}
 		}
+		weekStart := StartOfWeek(day)
 
-		err = r.ParseForm()
+		summary, err := s.Meals.GetWeeklyMealSummary(r.Context(), repo.User{Id: userId}, weekStart)
 		if err != nil {
-			ServerError(w, err)
+			s.ServerError(w, r, err)
 			return
 		}
 
-		meals := []repo.MealType{
-			repo.Breakfast,
-			repo.Lunch,
-			repo.Dinner,
-			repo.Snacks,
+		data := ViewData{
+			SiteData:  s.SiteData,
+			Flash:     s.popFlash(w, r),
+			CSRFToken: CSRFToken(r),
+			WeekStart: weekStart.Format(repo.DateFormat),
+			PrevWeek:  weekStart.AddDate(0, 0, -7).Format(repo.DateFormat),
+			NextWeek:  weekStart.AddDate(0, 0, 7).Format(repo.DateFormat),
 		}
+		data.SiteData.Title += " | Week of " + data.WeekStart
 
-		var data FormData
-		for _, meal := range meals {
-			str := r.Form.Get(string(meal))
-			if str != "" {
-				data.Name = str
-				data.MealType = meal
-				break
-			}
+		for i := 0; i < 7; i++ {
+			date := weekStart.AddDate(0, 0, i).Format(repo.DateFormat)
+			data.Days = append(data.Days, Day{Date: date, Meals: summary[date]})
 		}
 
-		if data.Name == "" {
-			http.Error(w, "Error, recieved an empty form submission!", http.StatusBadRequest)
-			return
+		if err := RenderTemplate(w, tmpl, "root", data); err != nil {
+			s.ServerError(w, r, err)
 		}
+	}
+}
 
-		// create and insert meal record into the database
-		_, err = repo.InsertMeal(repo.NewMeal(data.Name, userId, data.MealType, time.Now()))
-		if err != nil {
-			ServerError(w, err)
-			return
-		}
+func (s *Server) handleMonthly(view []HTMLFile) http.HandlerFunc {
+	type Day struct {
+		Date  string
+		Meals []repo.Meal
+	}
 
-		// re-render the today page by redirect
-		http.Redirect(w, r, "/today", http.StatusSeeOther)
+	type ViewData struct {
+		SiteData  SiteData
+		Flash     FlashData
+		CSRFToken string
+		Month     string
+		PrevMonth time.Time
+		NextMonth time.Time
+		Days      []Day
+	}
+
+	tmpl, err := s.CompileTemplates("monthly.html", view, nil)
+	if err != nil {
+		return s.errorHandler(err)
 	}
-}
 
-func (s *Server) handleDeleteMeal() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := chi.URLParam(r, "id")
+		userId := MustGetUserId(r)
 
-		userId, err := GetUserId(r, s.Sessions)
-		if err != nil {
-			ServerError(w, err)
-			return
+		now := time.Now()
+		year, month := now.Year(), now.Month()
+
+		query := r.URL.Query()
+		if yearStr := query.Get("year"); yearStr != "" {
+			y, err := strconv.Atoi(yearStr)
+			if err != nil || y < 1 || y > 9999 {
+				http.Error(w, "Invalid year", http.StatusBadRequest)
+				return
+			}
+			year = y
+		}
+		if monthStr := query.Get("month"); monthStr != "" {
+			m, err := strconv.Atoi(monthStr)
+			if err != nil || m < 1 || m > 12 {
+				http.Error(w, "Invalid month", http.StatusBadRequest)
+				return
+			}
+			month = time.Month(m)
 		}
 
-		err = repo.DeleteMealByUserAndId(repo.User{Id: userId}, id)
+		summary, err := s.Meals.GetMonthlyMealSummary(r.Context(), repo.User{Id: userId}, year, month)
 		if err != nil {
-			ServerError(w, err)
+			s.ServerError(w, r, err)
 			return
 		}
 
-		w.Header().Add("HX-Redirect", "/today")
+		first := time.Date(year, month, 1, 0, 0, 0, 0, time.Local)
+		data := ViewData{
+			SiteData:  s.SiteData,
+			Flash:     s.popFlash(w, r),
+			CSRFToken: CSRFToken(r),
+			Month:     first.Format("January 2006"),
+			PrevMonth: first.AddDate(0, -1, 0),
+			NextMonth: first.AddDate(0, 1, 0),
+		}
+		data.SiteData.Title += " | " + data.Month
+
+		// only days with meals are listed, in order
+		for day := first; day.Month() == month; day = day.AddDate(0, 0, 1) {
+			date := day.Format(repo.DateFormat)
+			if meals, ok := summary[date]; ok {
+				data.Days = append(data.Days, Day{Date: date, Meals: meals})
+			}
+		}
+
+		if err := RenderTemplate(w, tmpl, "root", data); err != nil {
+			s.ServerError(w, r, err)
+		}
+	}
+}
+
+// this is called by HTMX
+func (s *Server) handleLogout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			// delete the session by removing the user id from session values
+			session, _ := s.Sessions.Get(r, SessionName)
+			delete(session.Values, userIdKey)
+			delete(session.Values, rememberKey)
+			session.Options.MaxAge = 0
+			err := sessions.Save(r, w)
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+			// send the user back to login
+			w.Header().Add("HX-Redirect", "/login")
+		}
+	}
+}
+
+func (s *Server) handleMeals() http.HandlerFunc {
+	type FormData struct {
+		Name     string
+		MealType repo.MealType
+		Notes    string
+		Photo    *multipart.FileHeader // optional, only sent with multipart forms
+
+		// optional nutrition values
+		Calories *float64
+		Protein  *float64
+		Carbs    *float64
+		Fat      *float64
+
+		// optional portion size
+		Quantity *float64
+		Unit     string
+
+		Tags []string
+	}
+
+	type ViewData struct {
+		Sections []MealSection
+	}
+
+	// HTMX requests only need the refreshed table
+	tmpl, err := s.CompileTemplates("table.html", []HTMLFile{TableHTMLComponent}, nil)
+	if err != nil {
+		return s.errorHandler(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+		var err error
+
+		// forms carrying a photo are multipart, plain HTMX forms remain url-encoded
+		if IsMultipart(r) {
+			err = r.ParseMultipartForm(MaxUploadSize)
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		// the today form sends name and meal_type, older clients send the name
+		// in a field named after its meal type
+		data := FormData{
+			Name:     r.Form.Get("name"),
+			MealType: repo.MealType(r.Form.Get("meal_type")),
+		}
+		if data.Name == "" {
+			for _, meal := range repo.MealTypes {
+				str := r.Form.Get(string(meal))
+				if str != "" {
+					data.Name = str
+					data.MealType = meal
+					break
+				}
+			}
+		}
+
+		if data.Name == "" {
+			http.Error(w, "Error, recieved an empty form submission!", http.StatusBadRequest)
+			return
+		}
+
+		if !repo.IsValidMealType(data.MealType) {
+			http.Error(w, fmt.Sprintf("Error, unknown meal type %q, expected one of %v", data.MealType, repo.MealTypes), http.StatusBadRequest)
+			return
+		}
+
+		data.Notes = r.Form.Get("notes")
+
+		nutrition := map[string]**float64{
+			"calories": &data.Calories,
+			"protein":  &data.Protein,
+			"carbs":    &data.Carbs,
+			"fat":      &data.Fat,
+		}
+		for field, dst := range nutrition {
+			value, err := ParseOptionalFloat(r.Form.Get(field))
+			if err != nil {
+				http.Error(w, "Error, "+field+" must be a number", http.StatusBadRequest)
+				return
+			}
+			*dst = value
+		}
+
+		data.Quantity, err = ParseOptionalFloat(r.Form.Get("quantity"))
+		if err != nil {
+			http.Error(w, "Error, quantity must be a number", http.StatusBadRequest)
+			return
+		}
+		data.Unit = strings.TrimSpace(r.Form.Get("unit"))
+		data.Tags = repo.ParseTags(r.Form.Get("tags"))
+
+		if r.MultipartForm != nil {
+			// browsers send an empty file when no photo was picked
+			if photos := r.MultipartForm.File["photo"]; len(photos) > 0 && photos[0].Size > 0 {
+				data.Photo = photos[0]
+			}
+		}
+
+		// check the photo before anything is stored
+		var photo []byte
+		var photoExt string
+		if data.Photo != nil {
+			photo, photoExt, err = ReadPhoto(data.Photo)
+			if errors.Is(err, ErrPhotoTooLarge) || errors.Is(err, ErrPhotoType) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+		}
+
+		opts := []repo.MealOption{repo.WithNotes(data.Notes), repo.WithTags(data.Tags...)}
+		if data.Quantity != nil {
+			opts = append(opts, repo.WithQuantity(*data.Quantity, data.Unit))
+		}
+
+		// create and insert meal record into the database
+		meal := repo.NewMealWithNutrition(data.Name, userId, data.MealType, time.Now(), data.Calories, data.Protein, data.Carbs, data.Fat, opts...)
+		meal, err = s.Meals.InsertMeal(r.Context(), meal)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		if photo != nil {
+			path, err := SaveMealPhoto(s.Config.UploadDir, userId, meal.Id, photo, photoExt)
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+
+			err = s.Meals.SetMealPhoto(r.Context(), meal.Id, userId, path)
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+		}
+
+		if r.Header.Get("HX-Request") == "true" {
+			meals, err := s.Meals.GetMealsByUserAndDateSortedByType(r.Context(), repo.User{Id: userId}, time.Now())
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+
+			if err := RenderTemplate(w, tmpl, "today-table", ViewData{Sections: mealSections(meals)}); err != nil {
+				s.ServerError(w, r, err)
+			}
+			return
+		}
+
+		err = SetFlash(r, w, s.Sessions, "Meal added!", FlashSuccess)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		// re-render the today page by redirect
+		http.Redirect(w, r, "/today", http.StatusSeeOther)
+	}
+}
+
+// handleDuplicateMeal logs a meal again, now or at the optional
+// {"date_consumed": "2006-01-02 15:04:05"} in the body.
+func (s *Server) handleDuplicateMeal() http.HandlerFunc {
+	type Request struct {
+		DateConsumed string `json:"date_consumed"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid meal id", http.StatusBadRequest)
+			return
+		}
+
+		// the body is optional
+		var req Request
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		at := time.Now()
+		if req.DateConsumed != "" {
+			at, err = time.ParseInLocation(repo.Timestamp, req.DateConsumed, time.Local)
+			if err != nil {
+				http.Error(w, "date_consumed must be formatted as "+repo.Timestamp, http.StatusBadRequest)
+				return
+			}
+		}
+
+		meal, err := s.Meals.DuplicateMeal(r.Context(), id, userId, at)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Meal not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusCreated, meal)
+	}
+}
+
+// handleUploadMealPhoto attaches a JPEG or PNG photo, sent as the "photo"
+// field of a multipart form, to one of the user's meals. It replaces any
+// earlier photo and responds with the updated meal.
+func (s *Server) handleUploadMealPhoto() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid meal id", http.StatusBadRequest)
+			return
+		}
+
+		// leave room for the multipart framing around the photo
+		r.Body = http.MaxBytesReader(w, r.Body, MaxPhotoSize+1<<20)
+		err = r.ParseMultipartForm(MaxUploadSize)
+		if err != nil {
+			http.Error(w, "Expected a multipart form with a photo of at most 5 MB", http.StatusBadRequest)
+			return
+		}
+
+		_, fh, err := r.FormFile("photo")
+		if err != nil {
+			http.Error(w, "Missing photo", http.StatusBadRequest)
+			return
+		}
+
+		// make sure the meal is the user's before writing anything to disk
+		_, err = s.Meals.GetMealByIdAndUser(r.Context(), id, repo.User{Id: userId})
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Meal not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		photo, ext, err := ReadPhoto(fh)
+		if errors.Is(err, ErrPhotoTooLarge) || errors.Is(err, ErrPhotoType) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		path, err := SaveMealPhoto(s.Config.UploadDir, userId, id, photo, ext)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		err = s.Meals.SetMealPhoto(r.Context(), id, userId, path)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		meal, err := s.Meals.GetMealByIdAndUser(r.Context(), id, repo.User{Id: userId})
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, meal)
+	}
+}
+
+// handleUploads serves the files the user uploaded, which SaveMealPhoto keeps
+// under uploads/{userId}/. Other users' files and directories are not found,
+// so nothing can be listed.
+func (s *Server) handleUploads() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		// clean before checking the owner, so "1/../2" cannot reach user 2
+		name := path.Clean("/" + chi.URLParam(r, "*"))
+		if !strings.HasPrefix(name, "/"+strconv.FormatInt(userId, 10)+"/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		f, err := http.Dir(s.Config.UploadDir).Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	}
+}
+
+// handleDeleteMeal removes one of the user's meals along with its photo. HTMX
+// requests that pass ?meal_type= get back that type's section of today's table
+// to swap in, other HTMX requests are sent back to today.
+func (s *Server) handleDeleteMeal() http.HandlerFunc {
+	tmpl, err := s.CompileTemplates("table.html", []HTMLFile{TableHTMLComponent}, nil)
+	if err != nil {
+		return s.errorHandler(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		userId := MustGetUserId(r)
+
+		mealId, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid meal id", http.StatusBadRequest)
+			return
+		}
+
+		mealType := repo.MealType(r.URL.Query().Get("meal_type"))
+		if mealType != "" && !repo.IsValidMealType(mealType) {
+			http.Error(w, "Invalid meal type", http.StatusBadRequest)
+			return
+		}
+
+		// look the meal up first, its photo path is gone once the row is
+		meal, err := s.Meals.GetMealByIdAndUser(r.Context(), mealId, repo.User{Id: userId})
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		err = s.Meals.DeleteMealByUserAndId(r.Context(), repo.User{Id: userId}, id)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		// the meal is already gone, so a leftover file is only logged
+		if meal.PhotoPath != "" {
+			if err := RemoveMealPhoto(s.Config.UploadDir, meal.PhotoPath); err != nil {
+				s.Logger.Error("removing meal photo", "error", err, "path", meal.PhotoPath)
+			}
+		}
+
+		isHTMX := r.Header.Get("HX-Request") == "true"
+		if isHTMX && mealType != "" {
+			meals, err := s.Meals.GetMealsByUserAndDateSortedByType(r.Context(), repo.User{Id: userId}, time.Now())
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+
+			section := MealSection{MealType: mealType}
+			for _, meal := range meals {
+				if repo.MealType(meal.MealType) == mealType {
+					section.Meals = append(section.Meals, meal)
+				}
+			}
+
+			if err := RenderTemplate(w, tmpl, "meal-section", section); err != nil {
+				s.ServerError(w, r, err)
+			}
+			return
+		}
+
+		// HTMX still follows HX-Redirect on a 204
+		if isHTMX {
+			w.Header().Set("HX-Redirect", "/today")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleGetMeals() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+		user := repo.User{Id: userId}
+
+		var meals []repo.Meal
+		var err error
+		if dateStr := r.URL.Query().Get("date"); dateStr != "" {
+			date, err := time.Parse(repo.DateFormat, dateStr)
+			if err != nil {
+				http.Error(w, "Invalid date format", http.StatusBadRequest)
+				return
+			}
+			meals, err = s.Meals.GetMealsByUserAndDate(r.Context(), user, date)
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+		} else if tag := r.URL.Query().Get("tag"); tag != "" {
+			meals, err = s.Meals.GetMealsByTag(r.Context(), user, tag)
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+		} else {
+			meals, err = s.Meals.GetAllMealsByUser(r.Context(), user)
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+		}
+
+		meals, err = s.Meals.LoadTags(r.Context(), meals)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		// always encode an array, even when there are no meals
+		if meals == nil {
+			meals = []repo.Meal{}
+		}
+
+		WriteJSON(w, http.StatusOK, meals)
+	}
+}
+
+// handleMealDates lists the days the user logged meals on as "YYYY-MM-DD",
+// for highlighting active days in a calendar.
+func (s *Server) handleMealDates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		dates, err := s.Meals.GetDistinctMealDates(r.Context(), repo.User{Id: userId})
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		days := make([]string, len(dates))
+		for i, date := range dates {
+			days[i] = date.Format(repo.DateFormat)
+		}
+
+		WriteJSON(w, http.StatusOK, days)
+	}
+}
+
+// handleSearchMeals answers JSON, or the meals table when called by HTMX.
+func (s *Server) handleSearchMeals() http.HandlerFunc {
+	type ViewData struct {
+		Meals []repo.Meal
+	}
+
+	tmpl, err := s.CompileTemplates("table.html", []HTMLFile{TableHTMLComponent}, nil)
+	if err != nil {
+		return s.errorHandler(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		if utf8.RuneCountInString(q) < MinSearchLength {
+			http.Error(w, fmt.Sprintf("Search must be at least %d characters", MinSearchLength), http.StatusBadRequest)
+			return
+		}
+
+		meals, err := s.Meals.SearchMealsByName(r.Context(), repo.User{Id: userId}, q, SearchLimit)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		if r.Header.Get("HX-Request") == "true" {
+			if err := RenderTemplate(w, tmpl, "table", ViewData{Meals: meals}); err != nil {
+				s.ServerError(w, r, err)
+			}
+			return
+		}
+
+		// always encode an array, even when there are no meals
+		if meals == nil {
+			meals = []repo.Meal{}
+		}
+
+		WriteJSON(w, http.StatusOK, meals)
+	}
+}
+
+// handleFoodSearch suggests foods matching ?q=. HTMX gets <option> elements
+// for a datalist, everyone else JSON.
+func (s *Server) handleFoodSearch() http.HandlerFunc {
+	type ViewData struct {
+		Foods []repo.FoodItem
+	}
+
+	tmpl, err := s.CompileTemplates("food-options.html", []HTMLFile{FoodOptionsHTMLComponent}, nil)
+	if err != nil {
+		return s.errorHandler(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		if utf8.RuneCountInString(q) < MinSearchLength {
+			http.Error(w, fmt.Sprintf("Search must be at least %d characters", MinSearchLength), http.StatusBadRequest)
+			return
+		}
+
+		foods, err := s.Foods.SearchFoods(q)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		if r.Header.Get("HX-Request") == "true" {
+			if err := RenderTemplate(w, tmpl, "food-options", ViewData{Foods: foods}); err != nil {
+				s.ServerError(w, r, err)
+			}
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, foods)
+	}
+}
+
+func (s *Server) handleExportCSV() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		meals, err := s.Meals.GetAllMealsByUser(r.Context(), repo.User{Id: userId})
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		meals, err = s.Meals.LoadTags(r.Context(), meals)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="meals.csv"`)
+
+		cw := csv.NewWriter(w)
+		cw.Write(MealCSVHeader)
+		for _, meal := range meals {
+			cw.Write(MealCSVRecord(meal))
+		}
+		cw.Flush()
+
+		// the status has already been sent, so all we can do is log
+		if err := cw.Error(); err != nil {
+			s.Logger.Error("csv export failed", "error", err)
+		}
+	}
+}
+
+// handleExportJSON downloads the user's meals, optionally limited to the days
+// between ?from= and ?to=.
+func (s *Server) handleExportJSON() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+		user := repo.User{Id: userId}
+
+		var meals []repo.Meal
+		var err error
+		fromStr := r.URL.Query().Get("from")
+		toStr := r.URL.Query().Get("to")
+		if fromStr != "" || toStr != "" {
+			if fromStr == "" || toStr == "" {
+				http.Error(w, "Both from and to are required for a date range", http.StatusBadRequest)
+				return
+			}
+
+			from, err := time.Parse(repo.DateFormat, fromStr)
+			if err != nil {
+				http.Error(w, "Invalid from date format", http.StatusBadRequest)
+				return
+			}
+
+			to, err := time.Parse(repo.DateFormat, toStr)
+			if err != nil {
+				http.Error(w, "Invalid to date format", http.StatusBadRequest)
+				return
+			}
+
+			meals, err = s.Meals.GetMealsByUserAndDateRange(r.Context(), user, from, to)
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+		} else {
+			meals, err = s.Meals.GetAllMealsByUser(r.Context(), user)
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+		}
+
+		// always encode an array, even when there are no meals
+		if meals == nil {
+			meals = []repo.Meal{}
+		}
+
+		w.Header().Set("Content-Disposition", `attachment; filename="meals.json"`)
+		WriteJSON(w, http.StatusOK, meals)
+	}
+}
+
+// handleImportCSV reads a CSV in the export format, or the original four
+// column format. The id column is ignored, every imported meal gets a new id.
+func (s *Server) handleImportCSV() http.HandlerFunc {
+	type Response struct {
+		Imported int `json:"imported"`
+		Skipped  int `json:"skipped"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		err := r.ParseMultipartForm(MaxUploadSize)
+		if err != nil {
+			http.Error(w, "Expected a multipart form", http.StatusBadRequest)
+			return
+		}
+
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Missing file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		cr := csv.NewReader(file)
+		cr.FieldsPerRecord = -1 // rows with the wrong number of columns are skipped below
+
+		// files without a header are in the original four column format
+		columns := make(map[string]int)
+		for i, name := range MealCSVHeader[:4] {
+			columns[name] = i
+		}
+		width := 4
+
+		var meals []repo.Meal
+		var skipped int
+		for line := 1; ; line++ {
+			record, err := cr.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				s.Logger.Warn("import: skipping line", "line", line, "error", err)
+				skipped++
+				continue
+			}
+			if err != nil {
+				http.Error(w, "Could not read file", http.StatusBadRequest)
+				return
+			}
+
+			// the header row names the columns
+			if line == 1 && len(record) > 0 && record[0] == "id" {
+				clear(columns)
+				for i, name := range record {
+					columns[name] = i
+				}
+				width = len(record)
+				continue
+			}
+
+			if len(record) != width {
+				s.Logger.Warn("import: skipping line", "line", line, "error", "wrong number of columns", "columns", len(record))
+				skipped++
+				continue
+			}
+
+			meal, err := ParseMealCSVRecord(columns, record, userId)
+			if err != nil {
+				s.Logger.Warn("import: skipping line", "line", line, "error", err)
+				skipped++
+				continue
+			}
+
+			meals = append(meals, meal)
+		}
+
+		// all or nothing, a failed commit leaves the diary untouched
+		inserted, err := s.Meals.BulkInsertMeals(r.Context(), meals)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, Response{Imported: len(inserted), Skipped: skipped})
+	}
+}
+
+func (s *Server) handleGetMeal() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Meal not found", http.StatusNotFound)
+			return
+		}
+
+		// meals belonging to other users are reported as missing
+		meal, err := s.Meals.GetMealByIdAndUser(r.Context(), id, repo.User{Id: userId})
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Meal not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		tagged, err := s.Meals.LoadTags(r.Context(), []repo.Meal{meal})
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, tagged[0])
+	}
+}
+
+func (s *Server) handleUpdateMeal() http.HandlerFunc {
+	// fields left out of the request body are not changed
+	type Request struct {
+		Name     *string  `json:"name"`
+		MealType *string  `json:"meal_type"`
+		Calories *float64 `json:"calories"`
+		Protein  *float64 `json:"protein"`
+		Carbs    *float64 `json:"carbs"`
+		Fat      *float64 `json:"fat"`
+		Notes    *string  `json:"notes"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+		user := repo.User{Id: userId}
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Meal not found", http.StatusNotFound)
+			return
+		}
+
+		var req Request
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		// start from the stored meal, which also checks it belongs to the user
+		meal, err := s.Meals.GetMealByIdAndUser(r.Context(), id, user)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Meal not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		if req.Name != nil {
+			if *req.Name == "" {
+				http.Error(w, "Name cannot be empty", http.StatusBadRequest)
+				return
+			}
+			meal.Name = *req.Name
+		}
+		if req.MealType != nil {
+			if !repo.IsValidMealType(repo.MealType(*req.MealType)) {
+				http.Error(w, "Unknown meal type", http.StatusBadRequest)
+				return
+			}
+			meal.MealType = *req.MealType
+		}
+		if req.Calories != nil {
+			meal.Calories = req.Calories
+		}
+		if req.Protein != nil {
+			meal.Protein = req.Protein
+		}
+		if req.Carbs != nil {
+			meal.Carbs = req.Carbs
+		}
+		if req.Fat != nil {
+			meal.Fat = req.Fat
+		}
+		if req.Notes != nil {
+			meal.Notes = *req.Notes
+		}
+
+		meal, err = s.Meals.UpdateMeal(r.Context(), user, meal)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Meal not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, meal)
+	}
+}
+
+func (s *Server) handleMealCount() http.HandlerFunc {
+	type Response struct {
+		Count int `json:"count"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+		user := repo.User{Id: userId}
+
+		query := r.URL.Query()
+		dateStr := query.Get("date")
+		fromStr := query.Get("from")
+		toStr := query.Get("to")
+		mealType := repo.MealType(query.Get("type"))
+
+		if mealType != "" && !repo.IsValidMealType(mealType) {
+			http.Error(w, "Invalid meal type", http.StatusBadRequest)
+			return
+		}
+
+		// a range needs both ends
+		if (fromStr == "") != (toStr == "") {
+			http.Error(w, "Both from and to are required", http.StatusBadRequest)
+			return
+		}
+
+		var from, to time.Time
+		var err error
+		if fromStr != "" {
+			from, err = time.Parse(repo.DateFormat, fromStr)
+			if err == nil {
+				to, err = time.Parse(repo.DateFormat, toStr)
+			}
+			if err != nil {
+				http.Error(w, "Invalid date format", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var count int
+		switch {
+		case mealType != "" && fromStr != "":
+			count, err = s.Meals.GetMealCountByTypeAndDateRange(r.Context(), user, mealType, from, to)
+		case mealType != "":
+			count, err = s.Meals.GetMealCountByType(r.Context(), user, mealType)
+		case fromStr != "":
+			count, err = s.Meals.GetMealCountByUserAndDateRange(r.Context(), user, from, to)
+		default:
+			// count today's meals unless a date is given
+			date := time.Now()
+			if dateStr != "" {
+				date, err = time.Parse(repo.DateFormat, dateStr)
+				if err != nil {
+					http.Error(w, "Invalid date format", http.StatusBadRequest)
+					return
+				}
+			}
+			count, err = s.Meals.GetMealCountByUserAndDate(r.Context(), user, date)
+		}
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, Response{Count: count})
+	}
+}
+
+func (s *Server) handleMealsFeed() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := repo.User{Id: MustGetUserId(r)}
+
+		meals, err := s.Meals.GetAllMealsByUser(r.Context(), user)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		// the feed only changes when a meal is added or removed
+		var last string
+		if len(meals) > 0 {
+			last = meals[len(meals)-1].DateConsumed
+		}
+		etag := fmt.Sprintf(`"%x"`, sha1.Sum([]byte(fmt.Sprintf("%s/%d", last, len(meals)))))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		feed, err := BuildAtomFeed(user, meals, BaseURL(r))
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.Write(feed)
+	}
+}
+
+// handleCopyMeals logs a previous day's meals again on another day, e.g. to
+// repeat yesterday's lunch.
+func (s *Server) handleCopyMeals() http.HandlerFunc {
+	type Request struct {
+		FromDate string `json:"from_date"`
+		ToDate   string `json:"to_date"`
+	}
+
+	type Response struct {
+		Copied int `json:"copied"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		var req Request
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		from, err := time.ParseInLocation(repo.DateFormat, req.FromDate, time.Local)
+		if err != nil {
+			http.Error(w, "Invalid from_date format", http.StatusBadRequest)
+			return
+		}
+
+		to, err := time.ParseInLocation(repo.DateFormat, req.ToDate, time.Local)
+		if err != nil {
+			http.Error(w, "Invalid to_date format", http.StatusBadRequest)
+			return
+		}
+
+		copied, err := s.Meals.CopyMealsToDate(r.Context(), repo.User{Id: userId}, from, to)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, Response{Copied: copied})
+	}
+}
+
+func (s *Server) handleMealBatch() http.HandlerFunc {
+	type MealEntry struct {
+		Name         string   `json:"name"`
+		MealType     string   `json:"meal_type"`
+		DateConsumed string   `json:"date_consumed"`
+		Calories     *float64 `json:"calories"`
+		Protein      *float64 `json:"protein"`
+		Carbs        *float64 `json:"carbs"`
+		Fat          *float64 `json:"fat"`
+	}
+
+	type Request struct {
+		Meals []MealEntry `json:"meals"`
+	}
+
+	type EntryError struct {
+		Index int    `json:"index"`
+		Error string `json:"error"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		var req Request
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json body"})
+			return
+		}
+
+		if len(req.Meals) > MaxBatchSize {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "batch too large"})
+			return
+		}
+
+		// validate every entry before touching the database
+		var meals []repo.Meal
+		var errs []EntryError
+		for i, entry := range req.Meals {
+			if entry.Name == "" {
+				errs = append(errs, EntryError{Index: i, Error: "name is required"})
+				continue
+			}
+
+			if !repo.IsValidMealType(repo.MealType(entry.MealType)) {
+				errs = append(errs, EntryError{Index: i, Error: "unknown meal type"})
+				continue
+			}
+
+			consumed := time.Now()
+			if entry.DateConsumed != "" {
+				consumed, err = time.ParseInLocation(repo.Timestamp, entry.DateConsumed, time.Local)
+				if err != nil {
+					errs = append(errs, EntryError{Index: i, Error: "date_consumed must be formatted as " + repo.Timestamp})
+					continue
+				}
+			}
+
+			meals = append(meals, repo.NewMealWithNutrition(entry.Name, userId, repo.MealType(entry.MealType), consumed, entry.Calories, entry.Protein, entry.Carbs, entry.Fat))
+		}
+
+		if len(errs) > 0 {
+			WriteJSON(w, http.StatusUnprocessableEntity, map[string][]EntryError{"errors": errs})
+			return
+		}
+
+		inserted, err := s.Meals.BulkInsertMeals(r.Context(), meals)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusCreated, inserted)
+	}
+}
+
+func (s *Server) handleMealSummary() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		limit := SummaryLimit
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			var err error
+			limit, err = strconv.Atoi(limitStr)
+			if err != nil || limit < 1 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		rows, err := s.Meals.GetDailySummaryRows(r.Context(), repo.User{Id: userId}, limit)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, rows)
+	}
+}
+
+// handleDayOfWeekStats reports, keyed by day name, how many meals the user
+// logged on each weekday and their average daily calories on it.
+func (s *Server) handleDayOfWeekStats() http.HandlerFunc {
+	type Day struct {
+		Meals           int     `json:"meals"`
+		AverageCalories float64 `json:"average_calories"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := repo.User{Id: MustGetUserId(r)}
+
+		counts, err := s.Meals.GetMealCountsByDayOfWeek(r.Context(), user)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		averages, err := s.Meals.GetCalorieAverageByDayOfWeek(r.Context(), user)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		res := make(map[string]Day, len(counts))
+		for dow := range counts {
+			res[time.Weekday(dow).String()] = Day{Meals: counts[dow], AverageCalories: averages[dow]}
+		}
+
+		WriteJSON(w, http.StatusOK, res)
+	}
+}
+
+func (s *Server) handleMealTypeCounts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		counts, err := s.Meals.GetMealCountByTypeForUser(r.Context(), repo.User{Id: userId})
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, counts)
+	}
+}
+
+func (s *Server) handleStreak() http.HandlerFunc {
+	type Response struct {
+		CurrentStreak int `json:"current_streak"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		streak, err := s.Meals.GetMealStreak(r.Context(), repo.User{Id: userId})
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, Response{CurrentStreak: streak})
+	}
+}
+
+// handleStatsSummary describes the user's whole diary. The entry dates are
+// null until the first meal is logged.
+func (s *Server) handleStatsSummary() http.HandlerFunc {
+	type Response struct {
+		FirstEntry    *string `json:"first_entry"`
+		LastEntry     *string `json:"last_entry"`
+		TotalMeals    int     `json:"total_meals"`
+		CurrentStreak int     `json:"current_streak"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := repo.User{Id: MustGetUserId(r)}
+		var err error
+
+		var res Response
+
+		res.TotalMeals, err = s.Meals.GetMealCountByUser(r.Context(), user)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		res.CurrentStreak, err = s.Meals.GetMealStreak(r.Context(), user)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		first, err := s.Meals.GetFirstMealDate(r.Context(), user)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		last, err := s.Meals.GetLastMealDate(r.Context(), user)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		if !first.IsZero() {
+			firstStr := first.Format(repo.Timestamp)
+			res.FirstEntry = &firstStr
+		}
+		if !last.IsZero() {
+			lastStr := last.Format(repo.Timestamp)
+			res.LastEntry = &lastStr
+		}
+
+		WriteJSON(w, http.StatusOK, res)
+	}
+}
+
+// handleCalorieStats returns daily calorie totals between ?from= and ?to=,
+// defaulting to the last SummaryLimit days.
+func (s *Server) handleCalorieStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+		var err error
+
+		to := time.Now()
+		from := to.AddDate(0, 0, -(SummaryLimit - 1))
+
+		fromStr := r.URL.Query().Get("from")
+		toStr := r.URL.Query().Get("to")
+		if fromStr != "" {
+			from, err = time.Parse(repo.DateFormat, fromStr)
+			if err != nil {
+				http.Error(w, "Invalid from date format", http.StatusBadRequest)
+				return
+			}
+		}
+		if toStr != "" {
+			to, err = time.Parse(repo.DateFormat, toStr)
+			if err != nil {
+				http.Error(w, "Invalid to date format", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if from.After(to) {
+			http.Error(w, "from must not be after to", http.StatusBadRequest)
+			return
+		}
+
+		totals, err := s.Meals.GetDailyCalorieTotals(r.Context(), repo.User{Id: userId}, from, to)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, totals)
+	}
+}
+
+// handleMealsPerDay returns a meal count for every day between ?from= and
+// ?to=, defaulting to the last SummaryLimit days.
+func (s *Server) handleMealsPerDay() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+		var err error
+
+		to := time.Now()
+		from := to.AddDate(0, 0, -(SummaryLimit - 1))
+
+		if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+			from, err = time.ParseInLocation(repo.DateFormat, fromStr, time.Local)
+			if err != nil {
+				http.Error(w, "Invalid from date format", http.StatusBadRequest)
+				return
+			}
+		}
+		if toStr := r.URL.Query().Get("to"); toStr != "" {
+			to, err = time.ParseInLocation(repo.DateFormat, toStr, time.Local)
+			if err != nil {
+				http.Error(w, "Invalid to date format", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if from.After(to) {
+			http.Error(w, "from must not be after to", http.StatusBadRequest)
+			return
+		}
+		if to.Sub(from) > MaxSeriesDays*24*time.Hour {
+			http.Error(w, fmt.Sprintf("The range can be at most %d days", MaxSeriesDays), http.StatusBadRequest)
+			return
+		}
+
+		counts, err := s.Meals.GetMealsPerDay(r.Context(), repo.User{Id: userId}, from, to)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, counts)
+	}
+}
+
+func (s *Server) handleFrequentMeals() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		limit, err := PositiveIntParam(r, "limit", FrequentLimit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		meals, err := s.Meals.GetMostFrequentMeals(r.Context(), repo.User{Id: userId}, min(limit, MaxPerPage))
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, meals)
+	}
+}
+
+// handleDeleteMeals purges a user's old history. It is destructive, so the
+// caller must pass ?confirm=true and cannot delete the last MinPurgeAge days.
+// Requests with ?date= clear a single day instead, see handleDeleteMealsByDate.
+func (s *Server) handleDeleteMeals() http.HandlerFunc {
+	type Response struct {
+		Deleted int64 `json:"deleted"`
+	}
+
+	byDate := s.handleDeleteMealsByDate()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		query := r.URL.Query()
+		if query.Has("date") {
+			byDate(w, r)
+			return
+		}
+
+		if query.Get("confirm") != "true" {
+			http.Error(w, "Bulk deletion requires ?confirm=true", http.StatusBadRequest)
+			return
+		}
+
+		before, err := time.ParseInLocation(repo.DateFormat, query.Get("before"), time.Local)
+		if err != nil {
+			http.Error(w, "Invalid date format", http.StatusBadRequest)
+			return
+		}
+
+		if before.After(time.Now().AddDate(0, 0, -MinPurgeAge)) {
+			http.Error(w, fmt.Sprintf("The before date must be at least %d days in the past", MinPurgeAge), http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := s.Meals.DeleteMealsByUserBefore(r.Context(), repo.User{Id: userId}, before)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		s.Logger.Info("meals purged", "user_id", userId, "deleted", deleted, "before", before.Format(repo.DateFormat))
+
+		WriteJSON(w, http.StatusOK, Response{Deleted: deleted})
+	}
+}
+
+// handleDeleteMealsByDate clears every meal logged on ?date=. The JSON body
+// must be {"confirm": true} so a stray request cannot wipe a day.
+func (s *Server) handleDeleteMealsByDate() http.HandlerFunc {
+	type Request struct {
+		Confirm bool `json:"confirm"`
+	}
+
+	type Response struct {
+		Deleted int64 `json:"deleted"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		date, err := time.ParseInLocation(repo.DateFormat, r.URL.Query().Get("date"), time.Local)
+		if err != nil {
+			http.Error(w, "Invalid date format", http.StatusBadRequest)
+			return
+		}
+
+		var req Request
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || !req.Confirm {
+			http.Error(w, `Deleting a day requires a {"confirm": true} body`, http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := s.Meals.DeleteMealsByUserAndDate(r.Context(), repo.User{Id: userId}, date)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		s.Logger.Info("meals deleted", "user_id", userId, "deleted", deleted, "date", date.Format(repo.DateFormat))
+
+		WriteJSON(w, http.StatusOK, Response{Deleted: deleted})
+	}
+}
+
+func (s *Server) handleGetMe() http.HandlerFunc {
+	// only what is safe to share, the password hash stays on the server
+	type Response struct {
+		Id    int64  `json:"id"`
+		Email string `json:"email"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		// the session may outlive the account
+		user, err := repo.GetUserById(r.Context(), userId)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, Response{Id: user.Id, Email: user.Email})
+	}
+}
+
+// errWrongPassword is returned by changePassword when the current password
+// does not match.
+var errWrongPassword = errors.New("Current password is incorrect")
+
+// changePassword replaces the user's password with newPassword, which must
+// already be validated, once current has been checked against the stored
+// hash. A deleted account gives sql.ErrNoRows.
+func changePassword(ctx context.Context, userId int64, current, newPassword string) error {
+	user, err := repo.GetUserById(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	// compare the hashed passwords
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(current))
+	if err != nil {
+		return errWrongPassword
+	}
+
+	// hash the new password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), 10)
+	if err != nil {
+		return err
+	}
+
+	return repo.UpdateUserPassword(ctx, user.Id, string(hashedPassword))
+}
+
+// handleChangePasswordPage is the form version of handleChangePassword, where
+// AuthMiddleware sends users whose password has expired.
+func (s *Server) handleChangePasswordPage(view []HTMLFile) http.HandlerFunc {
+	type ViewData struct {
+		SiteData     SiteData
+		Flash        FlashData
+		CSRFToken    string
+		ErrorMessage string
+	}
+
+	tmpl, err := s.CompileTemplates("change-password.html", view, nil)
+	if err != nil {
+		return s.errorHandler(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := ViewData{
+			SiteData:  s.SiteData,
+			CSRFToken: CSRFToken(r),
+		}
+		data.SiteData.Title += " | Change password"
+
+		if r.Method == "GET" {
+			data.Flash = s.popFlash(w, r)
+			if err := RenderTemplate(w, tmpl, "root", data); err != nil {
+				s.ServerError(w, r, err)
+			}
+		}
+
+		if r.Method == "POST" {
+			r.ParseForm()
+			newPassword := r.Form.Get("new_password")
+
+			if err := validatePassword(newPassword); err != nil {
+				data.ErrorMessage = err.Error()
+				if err := RenderTemplate(w, tmpl, "root", data); err != nil {
+					s.ServerError(w, r, err)
+				}
+				return
+			}
+
+			err := changePassword(r.Context(), MustGetUserId(r), r.Form.Get("current_password"), newPassword)
+			if errors.Is(err, errWrongPassword) {
+				data.ErrorMessage = err.Error()
+				if err := RenderTemplate(w, tmpl, "root", data); err != nil {
+					s.ServerError(w, r, err)
+				}
+				return
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Redirect(w, r, LoginRedirect(r), http.StatusSeeOther)
+				return
+			}
+			if err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+
+			if err := SetFlash(r, w, s.Sessions, "Password changed.", FlashSuccess); err != nil {
+				s.ServerError(w, r, err)
+				return
+			}
+			http.Redirect(w, r, "/today", http.StatusSeeOther)
+		}
+	}
+}
+
+func (s *Server) handleChangePassword() http.HandlerFunc {
+	type Request struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		var req Request
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		if err := validatePassword(req.NewPassword); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = changePassword(r.Context(), userId, req.CurrentPassword, req.NewPassword)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, errWrongPassword) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleDeleteAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := MustGetUserId(r)
+
+		err := repo.DeleteUser(r.Context(), userId)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		// the account is already gone, so leftover files are only logged
+		if err := RemoveUserUploads(s.Config.UploadDir, userId); err != nil {
+			s.Logger.Error("removing user uploads", "error", err, "user_id", userId)
+		}
+
+		// expire the cookie so the deleted user id cannot be reused
+		session, _ := s.Sessions.Get(r, SessionName)
+		delete(session.Values, userIdKey)
+		session.Options.MaxAge = -1
+		err = sessions.Save(r, w)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleHealthCheck is an unauthenticated liveness probe.
+func (s *Server) handleHealthCheck() http.HandlerFunc {
+	type Response struct {
+		Status string `json:"status"`
+		DB     string `json:"db"`
+		Error  string `json:"error,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := repo.Ping(r.Context()); err != nil {
+			s.Logger.Error("health check failed", "error", err)
+			WriteJSON(w, http.StatusServiceUnavailable, Response{Status: "degraded", DB: "down", Error: err.Error()})
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, Response{Status: "ok", DB: "up"})
+	}
+}
+
+func (s *Server) handleAdminStats() http.HandlerFunc {
+	type Response struct {
+		UserCount   int   `json:"user_count"`
+		TotalMeals  int   `json:"total_meals"`
+		DBSizeBytes int64 `json:"db_size_bytes"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		users, err := repo.GetUserCount(r.Context())
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		meals, err := s.Meals.GetTotalMealCount(r.Context())
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		size, err := dbFileSize()
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, Response{UserCount: users, TotalMeals: meals, DBSizeBytes: size})
+	}
+}
+
+// handleAdminActiveUsers lists who logged meals on ?date=, today by default.
+func (s *Server) handleAdminActiveUsers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		date := time.Now()
+		if dateStr := r.URL.Query().Get("date"); dateStr != "" {
+			var err error
+			date, err = time.ParseInLocation(repo.DateFormat, dateStr, time.Local)
+			if err != nil {
+				http.Error(w, "Invalid date format", http.StatusBadRequest)
+				return
+			}
+		}
+
+		users, err := repo.GetUsersWithMealCountsOnDate(r.Context(), date)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, users)
+	}
+}
+
+// handleAdminSearchUsers pages through the users whose email starts with ?q=,
+// returning only their id and email.
+func (s *Server) handleAdminSearchUsers() http.HandlerFunc {
+	type Response struct {
+		Users []repo.User `json:"users"`
+		Total int         `json:"total"`
+		Page  int         `json:"page"`
+		Limit int         `json:"limit"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, err := PositiveIntParam(r, "page", 1)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limit, err := PositiveIntParam(r, "limit", DefaultPerPage)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, MaxPerPage)
+
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		users, total, err := repo.SearchUsers(r.Context(), q, limit, (page-1)*limit)
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		// always encode an array, even when no one matches
+		if users == nil {
+			users = []repo.User{}
+		}
+
+		WriteJSON(w, http.StatusOK, Response{Users: users, Total: total, Page: page, Limit: limit})
+	}
+}
+
+// handleAdminVacuum compacts the database file after many deletes.
+func (s *Server) handleAdminVacuum() http.HandlerFunc {
+	type Response struct {
+		Status     string `json:"status"`
+		DurationMs int64  `json:"duration_ms"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		before, err := dbFileSize()
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		start := time.Now()
+		err = repo.Vacuum()
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+		elapsed := time.Since(start)
+
+		after, err := dbFileSize()
+		if err != nil {
+			s.ServerError(w, r, err)
+			return
+		}
+
+		s.Logger.Info("database vacuumed", "before_bytes", before, "after_bytes", after, "duration", elapsed)
+
+		WriteJSON(w, http.StatusOK, Response{Status: "ok", DurationMs: elapsed.Milliseconds()})
+	}
+}
+
+// dbFileSize returns the size of the database file. An in-memory database has
+// no file, its size is reported as 0.
+func dbFileSize() (int64, error) {
+	path := repo.DBPath()
+	if path == ":memory:" {
+		return 0, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+func (s *Server) handleLatency() http.HandlerFunc {
+	type RouteLatency struct {
+		P50 float64 `json:"p50_ms"`
+		P95 float64 `json:"p95_ms"`
+		P99 float64 `json:"p99_ms"`
+	}
+
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		res := make(map[string]RouteLatency)
+		for _, route := range s.Latency.Routes() {
+			res[route] = RouteLatency{
+				P50: ms(s.Latency.P50(route)),
+				P95: ms(s.Latency.P95(route)),
+				P99: ms(s.Latency.P99(route)),
+			}
+		}
+
+		WriteJSON(w, http.StatusOK, res)
 	}
 }
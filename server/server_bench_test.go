@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/connorkuljis/food-diary/repo"
+)
+
+const (
+	benchMealCount        = 100  // meals logged today before benchmarking
+	benchHistoryMealCount = 200  // meals logged before BenchmarkGzipHistory
+	benchTodayRequests    = 1000 // requests per iteration of BenchmarkHandleToday
+)
+
+// insertBenchMeals logs n meals for user today, spread across the meal types.
+func insertBenchMeals(b *testing.B, user repo.User, n int) {
+	b.Helper()
+
+	now := time.Now()
+	meals := make([]repo.Meal, n)
+	for i := range meals {
+		mealType := repo.MealTypes[i%len(repo.MealTypes)]
+		meals[i] = repo.NewMeal("meal "+strconv.Itoa(i), user.Id, mealType, now)
+	}
+
+	if _, err := repo.BulkInsertMeals(context.Background(), meals); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkHandleToday(b *testing.B) {
+	s := newTestServer(b)
+	user := newTestUser(b, "a@b.com")
+	insertBenchMeals(b, user, benchMealCount)
+	cookie := sessionCookie(b, s, user.Id)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchTodayRequests; j++ {
+			rec := serve(s, httptest.NewRequest(http.MethodGet, "/today", nil), cookie)
+			if rec.Code != http.StatusOK {
+				b.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+		}
+	}
+
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*benchTodayRequests), "ns/request")
+}
+
+func BenchmarkGetMealsByUserAndDate(b *testing.B) {
+	newTestServer(b)
+	user := newTestUser(b, "a@b.com")
+	insertBenchMeals(b, user, benchMealCount)
+	today := time.Now()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		meals, err := repo.GetMealsByUserAndDate(context.Background(), user, today)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(meals) != benchMealCount {
+			b.Fatalf("got %d meals, want %d", len(meals), benchMealCount)
+		}
+	}
+}
+
+func BenchmarkGzipHistory(b *testing.B) {
+	s := newTestServer(b)
+	user := newTestUser(b, "a@b.com")
+	insertBenchMeals(b, user, benchHistoryMealCount)
+	cookie := sessionCookie(b, s, user.Id)
+	path := "/history?date=" + time.Now().Format(repo.DateFormat)
+
+	plain := serve(s, httptest.NewRequest(http.MethodGet, path, nil), cookie)
+	if plain.Code != http.StatusOK {
+		b.Fatalf("status = %d, want %d", plain.Code, http.StatusOK)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var size int
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := serve(s, req, cookie)
+		if rec.Header().Get("Content-Encoding") != "gzip" {
+			b.Fatal("history was not gzipped")
+		}
+		size = rec.Body.Len()
+	}
+
+	b.ReportMetric(float64(plain.Body.Len()), "plain-bytes")
+	b.ReportMetric(float64(size), "gzip-bytes")
+}
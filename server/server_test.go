@@ -0,0 +1,1446 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	fooddiary "github.com/connorkuljis/food-diary"
+	"github.com/connorkuljis/food-diary/repo"
+	"github.com/go-chi/chi/v5/middleware"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testPassword  = "password123"
+	testCSRFToken = "test-csrf-token"
+)
+
+func TestMain(m *testing.M) {
+	// DropAndRecreateSchema refuses to run anywhere else
+	os.Setenv("GO_ENV", "test")
+
+	if err := repo.InitDB(":memory:"); err != nil {
+		panic(err)
+	}
+
+	// keep the access log out of test output and benchmark timings
+	middleware.DefaultLogger = middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: log.New(io.Discard, "", 0)})
+
+	os.Exit(m.Run())
+}
+
+// testConfig is the Config newTestServer routes with.
+func testConfig(t testing.TB) Config {
+	return Config{Port: Port, SessionSecret: "test-session-secret", UploadDir: t.TempDir()}
+}
+
+// newTestServer returns a fully routed Server on an empty database.
+func newTestServer(t testing.TB) *Server {
+	t.Helper()
+	return newTestServerWithConfig(t, testConfig(t))
+}
+
+// newTestServerWithConfig is newTestServer for tests of settings that Routes
+// reads, which cannot be changed once the server is routed.
+func newTestServerWithConfig(t testing.TB, cfg Config) *Server {
+	t.Helper()
+
+	if err := repo.DropAndRecreateSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewServerWithOptions(fooddiary.FS,
+		WithConfig(cfg),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithMealRepository(repo.NewSQLiteMealRepository(repo.DB())),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Routes()
+
+	return s
+}
+
+// newTestUser stores a user whose password is testPassword.
+func newTestUser(t testing.TB, email string) repo.User {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := repo.InsertUser(context.Background(), repo.NewUser(email, string(hash)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// sessionCookie builds the cookie of a session holding testCSRFToken and, when
+// userId is not 0, signed in as that user.
+func sessionCookie(t testing.TB, s *Server, userId int64) *http.Cookie {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, err := s.Sessions.New(req, SessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Values[csrfKey] = testCSRFToken
+	if userId != 0 {
+		session.Values[userIdKey] = userId
+	}
+
+	rec := httptest.NewRecorder()
+	if err := session.Save(req, rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Result().Cookies()[0]
+}
+
+// serve runs req through the router, as the user the cookie belongs to when it
+// is not nil.
+func serve(s *Server, req *http.Request, cookie *http.Cookie) *httptest.ResponseRecorder {
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+// postForm builds a url-encoded POST carrying the test CSRF token.
+func postForm(path string, form url.Values) *http.Request {
+	form.Set(CSRFFormField, testCSRFToken)
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestUnauthenticatedRequests(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		method   string
+		path     string
+		want     int
+		location string
+	}{
+		{http.MethodGet, "/today", http.StatusSeeOther, "/login?redirect=%2Ftoday"},
+		{http.MethodGet, "/history?date=2024-03-01", http.StatusSeeOther, "/login?redirect=%2Fhistory%3Fdate%3D2024-03-01"},
+		{http.MethodGet, "/weekly", http.StatusSeeOther, "/login?redirect=%2Fweekly"},
+		{http.MethodGet, "/monthly", http.StatusSeeOther, "/login?redirect=%2Fmonthly"},
+		{http.MethodGet, "/api/meals", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/api/users/me", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/api/stats/day-of-week", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/api/stats/meal-type-counts", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/api/stats/streak", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/api/stats/summary", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := serve(s, httptest.NewRequest(tt.method, tt.path, nil), nil)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+
+	tests := []struct {
+		name       string
+		email      string
+		password   string
+		wantSignIn bool
+	}{
+		{"correct password", "a@b.com", testPassword, true},
+		{"email with surrounding spaces", "  a@b.com ", testPassword, true},
+		{"wrong password", "a@b.com", "wrong-password", false},
+		{"unknown email", "nobody@b.com", testPassword, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := postForm("/login", url.Values{"email": {tt.email}, "password": {tt.password}})
+			rec := serve(s, req, sessionCookie(t, s, 0))
+
+			if !tt.wantSignIn {
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+				}
+				if !strings.Contains(rec.Body.String(), "Invalid email or password") {
+					t.Error("login page does not show the error")
+				}
+				return
+			}
+
+			if rec.Code != http.StatusSeeOther {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusSeeOther, rec.Body)
+			}
+			if got := rec.Header().Get("Location"); got != "/today" {
+				t.Errorf("Location = %q, want /today", got)
+			}
+
+			// the new session cookie must be signed in as the user
+			cookies := rec.Result().Cookies()
+			if len(cookies) == 0 {
+				t.Fatal("no session cookie set")
+			}
+			me := serve(s, httptest.NewRequest(http.MethodGet, "/api/users/me", nil), cookies[0])
+			if me.Code != http.StatusOK {
+				t.Fatalf("/api/users/me status = %d, want %d", me.Code, http.StatusOK)
+			}
+			if !strings.Contains(me.Body.String(), `"id":`+strconv.FormatInt(user.Id, 10)) {
+				t.Errorf("/api/users/me = %s, want user %d", me.Body, user.Id)
+			}
+		})
+	}
+}
+
+func TestLoginRememberMe(t *testing.T) {
+	s := newTestServer(t)
+	newTestUser(t, "a@b.com")
+
+	tests := []struct {
+		name       string
+		remember   string
+		wantMaxAge int
+	}{
+		{"remembered", "true", RememberMeMaxAge},
+		// 0 leaves Max-Age out, making it a browser session cookie
+		{"not remembered", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"email": {"a@b.com"}, "password": {testPassword}}
+			if tt.remember != "" {
+				form.Set("remember", tt.remember)
+			}
+			rec := serve(s, postForm("/login", form), sessionCookie(t, s, 0))
+			if rec.Code != http.StatusSeeOther {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusSeeOther)
+			}
+
+			header := rec.Header().Get("Set-Cookie")
+			wantAttr := "Max-Age=" + strconv.Itoa(tt.wantMaxAge)
+			if tt.wantMaxAge == 0 {
+				if strings.Contains(header, "Max-Age=") {
+					t.Errorf("Set-Cookie = %q, want no Max-Age", header)
+				}
+				return
+			}
+			if !strings.Contains(header, wantAttr) {
+				t.Errorf("Set-Cookie = %q, want %s", header, wantAttr)
+			}
+		})
+	}
+}
+
+func TestCreateMeal(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+	cookie := sessionCookie(t, s, user.Id)
+
+	rec := serve(s, postForm("/api/meals", url.Values{"lunch": {"soup"}, "calories": {"250"}}), cookie)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusSeeOther, rec.Body)
+	}
+
+	meals, err := repo.GetMealsByUserAndDate(context.Background(), user, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meals) != 1 {
+		t.Fatalf("%d meals stored, want 1", len(meals))
+	}
+	if meals[0].Name != "soup" || meals[0].MealType != string(repo.Lunch) {
+		t.Errorf("stored %q as %q, want soup as lunch", meals[0].Name, meals[0].MealType)
+	}
+	if meals[0].Calories == nil || *meals[0].Calories != 250 {
+		t.Errorf("calories = %v, want 250", meals[0].Calories)
+	}
+
+	// a missing CSRF token must not store anything
+	req := httptest.NewRequest(http.MethodPost, "/api/meals", strings.NewReader("lunch=bread"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if rec := serve(s, req, cookie); rec.Code != http.StatusForbidden {
+		t.Errorf("without a CSRF token status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestCreateMealHTMX(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+	cookie := sessionCookie(t, s, user.Id)
+
+	if _, err := repo.InsertMeal(context.Background(), repo.NewMeal("toast", user.Id, repo.Breakfast, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	req := postForm("/api/meals", url.Values{"lunch": {"soup"}})
+	req.Header.Set("HX-Request", "true")
+	rec := serve(s, req, cookie)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := rec.Header().Get("Location"); got != "" {
+		t.Errorf("HTMX request was redirected to %q", got)
+	}
+
+	// only today's table comes back, with the new meal and the earlier one
+	body := rec.Body.String()
+	if !strings.HasPrefix(strings.TrimSpace(body), "<table>") {
+		t.Errorf("response is not the table partial: %.80q", body)
+	}
+	for _, name := range []string{"toast", "soup"} {
+		if !strings.Contains(body, name) {
+			t.Errorf("table is missing %q", name)
+		}
+	}
+	if strings.Contains(body, "<html") {
+		t.Error("response contains the full page layout")
+	}
+}
+
+func TestDeleteMeal(t *testing.T) {
+	s := newTestServer(t)
+	owner := newTestUser(t, "a@b.com")
+	other := newTestUser(t, "c@d.com")
+	meal, err := repo.InsertMeal(context.Background(), repo.NewMeal("soup", owner.Id, repo.Lunch, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/meals/" + strconv.FormatInt(meal.Id, 10)
+
+	// someone else's delete leaves the meal alone
+	req := httptest.NewRequest(http.MethodDelete, path, nil)
+	req.Header.Set(CSRFHeader, testCSRFToken)
+	serve(s, req, sessionCookie(t, s, other.Id))
+	if _, err := repo.GetMealByIdAndUser(context.Background(), meal.Id, owner); err != nil {
+		t.Fatalf("meal gone after another user deleted it: %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, path, nil)
+	req.Header.Set(CSRFHeader, testCSRFToken)
+	rec := serve(s, req, sessionCookie(t, s, owner.Id))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if _, err := repo.GetMealByIdAndUser(context.Background(), meal.Id, owner); err == nil {
+		t.Error("meal still stored after deleting it")
+	}
+}
+
+func TestDeleteMealResponse(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+	cookie := sessionCookie(t, s, user.Id)
+
+	tests := []struct {
+		name         string
+		htmx         bool
+		wantRedirect string
+	}{
+		{"plain request", false, ""},
+		{"HTMX request is sent back to today", true, "/today"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meal, err := repo.InsertMeal(context.Background(), repo.NewMeal("soup", user.Id, repo.Lunch, time.Now()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/meals/"+strconv.FormatInt(meal.Id, 10), nil)
+			req.Header.Set(CSRFHeader, testCSRFToken)
+			if tt.htmx {
+				req.Header.Set("HX-Request", "true")
+			}
+			rec := serve(s, req, cookie)
+
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+			}
+			if got := rec.Header().Get("HX-Redirect"); got != tt.wantRedirect {
+				t.Errorf("HX-Redirect = %q, want %q", got, tt.wantRedirect)
+			}
+			if rec.Body.Len() != 0 {
+				t.Errorf("204 sent a body: %q", rec.Body)
+			}
+			if _, err := repo.GetMealByIdAndUser(context.Background(), meal.Id, user); err == nil {
+				t.Error("meal still stored after deleting it")
+			}
+		})
+	}
+}
+
+func TestDeleteMealSection(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+	cookie := sessionCookie(t, s, user.Id)
+
+	now := time.Now()
+	meals, err := repo.BulkInsertMeals(context.Background(), []repo.Meal{
+		repo.NewMeal("toast", user.Id, repo.Breakfast, now),
+		repo.NewMeal("porridge", user.Id, repo.Breakfast, now),
+		repo.NewMeal("soup", user.Id, repo.Lunch, now),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	toast, porridge := meals[0], meals[1]
+
+	// today's delete buttons ask for their own section back
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/today", nil), cookie)
+	link := fmt.Sprintf(`hx-delete="/api/meals/%d?meal_type=breakfast" hx-target="#meals-breakfast"`, toast.Id)
+	if !strings.Contains(rec.Body.String(), link) {
+		t.Errorf("today has no delete button for the breakfast section: %s", link)
+	}
+
+	deleteMeal := func(meal repo.Meal, mealType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/meals/%d?meal_type=%s", meal.Id, mealType), nil)
+		req.Header.Set(CSRFHeader, testCSRFToken)
+		req.Header.Set("HX-Request", "true")
+		return serve(s, req, cookie)
+	}
+
+	rec = deleteMeal(porridge, "brunch")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid meal type status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if _, err := repo.GetMealByIdAndUser(context.Background(), porridge.Id, user); err != nil {
+		t.Errorf("meal deleted despite the invalid meal type: %v", err)
+	}
+
+	rec = deleteMeal(toast, "breakfast")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("HX-Redirect"); got != "" {
+		t.Errorf("HX-Redirect = %q, want none", got)
+	}
+
+	body := rec.Body.String()
+	if !strings.HasPrefix(strings.TrimSpace(body), `<tbody id="meals-breakfast">`) {
+		t.Errorf("response is not the breakfast section: %.80q", body)
+	}
+	if !strings.Contains(body, "porridge") {
+		t.Error("section is missing the breakfast that is left")
+	}
+	for _, name := range []string{"toast", "soup"} {
+		if strings.Contains(body, name) {
+			t.Errorf("section shows %q", name)
+		}
+	}
+}
+
+func TestHistoryByDate(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+	other := newTestUser(t, "c@d.com")
+
+	meals := []repo.Meal{
+		repo.NewMeal("monday porridge", user.Id, repo.Breakfast, time.Date(2024, 3, 4, 8, 0, 0, 0, time.Local)),
+		repo.NewMeal("tuesday toast", user.Id, repo.Breakfast, time.Date(2024, 3, 5, 8, 0, 0, 0, time.Local)),
+		repo.NewMeal("tuesday soup", user.Id, repo.Lunch, time.Date(2024, 3, 5, 12, 0, 0, 0, time.Local)),
+		repo.NewMeal("someone else's lunch", other.Id, repo.Lunch, time.Date(2024, 3, 5, 12, 0, 0, 0, time.Local)),
+	}
+	if _, err := repo.BulkInsertMeals(context.Background(), meals); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		date    string
+		want    []string
+		notWant []string
+	}{
+		{"2024-03-04", []string{"monday porridge"}, []string{"tuesday toast", "tuesday soup"}},
+		{"2024-03-05", []string{"tuesday toast", "tuesday soup"}, []string{"monday porridge", "someone else&#39;s lunch", "someone else's lunch"}},
+		{"2024-03-06", nil, []string{"monday porridge", "tuesday toast", "tuesday soup"}},
+	}
+
+	cookie := sessionCookie(t, s, user.Id)
+	for _, tt := range tests {
+		t.Run(tt.date, func(t *testing.T) {
+			rec := serve(s, httptest.NewRequest(http.MethodGet, "/history?date="+tt.date, nil), cookie)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			body := rec.Body.String()
+			for _, name := range tt.want {
+				if !strings.Contains(body, name) {
+					t.Errorf("history is missing %q", name)
+				}
+			}
+			for _, name := range tt.notWant {
+				if strings.Contains(body, name) {
+					t.Errorf("history shows %q", name)
+				}
+			}
+		})
+	}
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/history?date=yesterday", nil), cookie)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid date status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestTodayEscapesFrequentMeals(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+
+	meal := repo.NewMeal("<script>alert(1)</script>", user.Id, repo.Snacks, time.Now().AddDate(0, 0, -1))
+	if _, err := repo.InsertMeal(context.Background(), meal); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/today", nil), sessionCookie(t, s, user.Id))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "<li>&lt;script&gt;alert(1)&lt;/script&gt; <small>") {
+		t.Error("frequent meal name is not escaped")
+	}
+}
+
+func TestExpiredRequestContext(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-1*time.Second))
+	defer cancel()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/meals", nil).WithContext(ctx)
+	rec := serve(s, req, sessionCookie(t, s, user.Id))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestMealCount(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+	other := newTestUser(t, "c@d.com")
+
+	day := func(d int) time.Time {
+		return time.Date(2024, 3, d, 12, 0, 0, 0, time.Local)
+	}
+	meals := []repo.Meal{
+		repo.NewMeal("porridge", user.Id, repo.Breakfast, day(1)),
+		repo.NewMeal("soup", user.Id, repo.Lunch, day(1)),
+		repo.NewMeal("toast", user.Id, repo.Breakfast, day(3)),
+		repo.NewMeal("curry", user.Id, repo.Dinner, day(5)),
+		repo.NewMeal("not mine", other.Id, repo.Breakfast, day(1)),
+	}
+	if _, err := repo.BulkInsertMeals(context.Background(), meals); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+		count int
+	}{
+		{"date", "date=2024-03-01", http.StatusOK, 2},
+		{"type only", "type=breakfast", http.StatusOK, 2},
+		{"range only", "from=2024-03-01&to=2024-03-03", http.StatusOK, 3},
+		{"type and range", "type=breakfast&from=2024-03-02&to=2024-03-05", http.StatusOK, 1},
+		{"invalid type", "type=brunch", http.StatusBadRequest, 0},
+		{"invalid type with range", "type=brunch&from=2024-03-01&to=2024-03-03", http.StatusBadRequest, 0},
+		{"from without to", "from=2024-03-01", http.StatusBadRequest, 0},
+		{"to without from", "to=2024-03-03", http.StatusBadRequest, 0},
+		{"invalid range date", "from=2024-03-01&to=tomorrow", http.StatusBadRequest, 0},
+		{"invalid date", "date=yesterday", http.StatusBadRequest, 0},
+	}
+
+	cookie := sessionCookie(t, s, user.Id)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/meals/count?"+tt.query, nil), cookie)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Count int `json:"count"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Count != tt.count {
+				t.Errorf("count = %d, want %d", res.Count, tt.count)
+			}
+		})
+	}
+}
+
+func TestDayOfWeekStats(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+
+	// 3 March 2024 is a Sunday, 4 March a Monday
+	calories := 600.0
+	meals := []repo.Meal{
+		repo.NewMealWithNutrition("roast", user.Id, repo.Dinner, time.Date(2024, 3, 3, 23, 59, 59, 0, time.Local), &calories, nil, nil, nil),
+		repo.NewMeal("toast", user.Id, repo.Breakfast, time.Date(2024, 3, 4, 0, 0, 0, 0, time.Local)),
+	}
+	if _, err := repo.BulkInsertMeals(context.Background(), meals); err != nil {
+		t.Fatal(err)
+	}
+	cookie := sessionCookie(t, s, user.Id)
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/stats/day-of-week", nil), cookie)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var res map[string]struct {
+		Meals           int     `json:"meals"`
+		AverageCalories float64 `json:"average_calories"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 7 {
+		t.Errorf("got %d days, want 7", len(res))
+	}
+	if got := res["Sunday"]; got.Meals != 1 || got.AverageCalories != calories {
+		t.Errorf("Sunday = %+v, want 1 meal averaging %v", got, calories)
+	}
+	if got := res["Monday"]; got.Meals != 1 || got.AverageCalories != 0 {
+		t.Errorf("Monday = %+v, want 1 meal without calories", got)
+	}
+
+	// the chart on today scales the bars to the highest day
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/today", nil), cookie)
+	body := rec.Body.String()
+	if !strings.Contains(body, `title="Sun: 600 kcal"`) || !strings.Contains(body, "height: 100%") {
+		t.Error("today does not chart Sunday's calories")
+	}
+}
+
+func TestAdminSearchUsers(t *testing.T) {
+	s := newTestServer(t)
+	admin := newTestUser(t, "admin@example.com")
+	s.Config.AdminUserID = admin.Id
+	for i := 0; i < 50; i++ {
+		email := fmt.Sprintf("user%02d@example.com", i)
+		if i%2 == 1 {
+			email = fmt.Sprintf("other%02d@example.com", i)
+		}
+		if _, err := repo.InsertUser(context.Background(), repo.NewUser(email, "not-a-real-hash")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/admin/users/search?q=user&page=2&limit=10", nil), sessionCookie(t, s, admin.Id))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if strings.Contains(rec.Body.String(), "password") || strings.Contains(rec.Body.String(), "hash") {
+		t.Errorf("response leaks password data: %s", rec.Body)
+	}
+
+	var res struct {
+		Users []struct {
+			Id    int64  `json:"id"`
+			Email string `json:"email"`
+		} `json:"users"`
+		Total int `json:"total"`
+		Page  int `json:"page"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 25 || res.Page != 2 {
+		t.Errorf("total = %d, page = %d, want 25 and 2", res.Total, res.Page)
+	}
+	if len(res.Users) != 10 {
+		t.Fatalf("got %d users, want 10", len(res.Users))
+	}
+	for _, user := range res.Users {
+		if !strings.HasPrefix(user.Email, "user") {
+			t.Errorf("%q does not match the search", user.Email)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		userId int64
+		query  string
+		want   int
+	}{
+		{"not an admin", newTestUser(t, "someone@example.com").Id, "q=user", http.StatusForbidden},
+		{"invalid page", admin.Id, "q=user&page=0", http.StatusBadRequest},
+		{"invalid limit", admin.Id, "q=user&limit=lots", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(s, httptest.NewRequest(http.MethodGet, "/admin/users/search?"+tt.query, nil), sessionCookie(t, s, tt.userId))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestTodayDefaultMealType(t *testing.T) {
+	tests := []struct {
+		name  string
+		meals []repo.MealType
+		want  string
+	}{
+		{"breakfast before any meal is logged", nil, "breakfast"},
+		{"type of the most frequent meal", []repo.MealType{repo.Dinner, repo.Dinner}, "dinner"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			user := newTestUser(t, "a@b.com")
+			for _, mealType := range tt.meals {
+				if _, err := repo.InsertMeal(context.Background(), repo.NewMeal("curry", user.Id, mealType, time.Now())); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rec := serve(s, httptest.NewRequest(http.MethodGet, "/today", nil), sessionCookie(t, s, user.Id))
+			body := rec.Body.String()
+			if !strings.Contains(body, `<option value="`+tt.want+`" selected>`) {
+				t.Errorf("%s is not pre-selected", tt.want)
+			}
+			if n := strings.Count(body, " selected>"); n != 1 {
+				t.Errorf("%d options are selected, want 1", n)
+			}
+		})
+	}
+}
+
+func TestCreateMealWithMealType(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+	cookie := sessionCookie(t, s, user.Id)
+
+	rec := serve(s, postForm("/api/meals", url.Values{"name": {"curry"}, "meal_type": {"dinner"}}), cookie)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusSeeOther, rec.Body)
+	}
+
+	meals, err := repo.GetMealsByUserAndType(context.Background(), user, repo.Dinner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meals) != 1 || meals[0].Name != "curry" {
+		t.Errorf("dinners = %v, want curry", meals)
+	}
+
+	rec = serve(s, postForm("/api/meals", url.Values{"name": {"curry"}, "meal_type": {"brunch"}}), cookie)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown meal type status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDeleteMealsPurge(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+	cookie := sessionCookie(t, s, user.Id)
+
+	now := time.Now()
+	for _, age := range []int{60, 60, 45, 0} {
+		if _, err := repo.InsertMeal(context.Background(), repo.NewMeal("soup", user.Id, repo.Lunch, now.AddDate(0, 0, -age))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := func(days int) string {
+		return now.AddDate(0, 0, -days).Format(repo.DateFormat)
+	}
+
+	// each case runs against what the previous ones left behind
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantDeleted int64
+		wantLeft    int
+	}{
+		{"without confirm", "before=" + before(MinPurgeAge), http.StatusBadRequest, 0, 4},
+		{"confirm not true", "confirm=yes&before=" + before(MinPurgeAge), http.StatusBadRequest, 0, 4},
+		{"inside the minimum age", "confirm=true&before=" + before(MinPurgeAge-1), http.StatusBadRequest, 0, 4},
+		{"at the minimum age", "confirm=true&before=" + before(MinPurgeAge), http.StatusOK, 3, 1},
+		{"nothing left to purge", "confirm=true&before=" + before(MinPurgeAge), http.StatusOK, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/api/meals?"+tt.query, nil)
+			req.Header.Set(CSRFHeader, testCSRFToken)
+			rec := serve(s, req, cookie)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				var got struct {
+					Deleted int64 `json:"deleted"`
+				}
+				if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+					t.Fatal(err)
+				}
+				if got.Deleted != tt.wantDeleted {
+					t.Errorf("deleted = %d, want %d", got.Deleted, tt.wantDeleted)
+				}
+			}
+
+			left, err := repo.GetMealCountByUser(context.Background(), user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if left != tt.wantLeft {
+				t.Errorf("%d meals left, want %d", left, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestUserIsolation(t *testing.T) {
+	s := newTestServer(t)
+	alice := newTestUser(t, "alice@example.com")
+	bob := newTestUser(t, "bob@example.com")
+	bobCookie := sessionCookie(t, s, bob.Id)
+
+	meal, err := repo.InsertMeal(context.Background(), repo.NewMeal("alice only soup", alice.Id, repo.Lunch, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mealPath := "/api/meals/" + strconv.FormatInt(meal.Id, 10)
+
+	t.Run("read", func(t *testing.T) {
+		rec := serve(s, httptest.NewRequest(http.MethodGet, mealPath, nil), sessionCookie(t, s, alice.Id))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("owner status = %d, want %d", rec.Code, http.StatusOK)
+		}
+
+		rec = serve(s, httptest.NewRequest(http.MethodGet, mealPath, nil), bobCookie)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+
+		for _, path := range []string{"/api/meals", "/today", "/history"} {
+			rec := serve(s, httptest.NewRequest(http.MethodGet, path, nil), bobCookie)
+			if strings.Contains(rec.Body.String(), "alice only soup") {
+				t.Errorf("%s shows alice's meal", path)
+			}
+		}
+	})
+
+	t.Run("export", func(t *testing.T) {
+		for _, path := range []string{"/api/meals/export.csv", "/api/meals/export.json"} {
+			rec := serve(s, httptest.NewRequest(http.MethodGet, path, nil), bobCookie)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s status = %d, want %d", path, rec.Code, http.StatusOK)
+			}
+			if strings.Contains(rec.Body.String(), "alice only soup") {
+				t.Errorf("%s exports alice's meal", path)
+			}
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, mealPath, nil)
+		req.Header.Set(CSRFHeader, testCSRFToken)
+		serve(s, req, bobCookie)
+
+		if _, err := repo.GetMealByIdAndUser(context.Background(), meal.Id, alice); err != nil {
+			t.Errorf("alice's meal is gone after bob deleted it: %v", err)
+		}
+	})
+}
+
+func TestMealBatchValidation(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+
+	body := `{"meals": [
+		{"name": "porridge", "meal_type": "breakfast"},
+		{"name": "", "meal_type": "lunch"},
+		{"name": "soup", "meal_type": "brunch"},
+		{"name": "curry", "meal_type": "dinner", "date_consumed": "last night"}
+	]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/meals/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(CSRFHeader, testCSRFToken)
+	rec := serve(s, req, sessionCookie(t, s, user.Id))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusUnprocessableEntity, rec.Body)
+	}
+
+	var got struct {
+		Errors []struct {
+			Index int `json:"index"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	var indexes []int
+	for _, e := range got.Errors {
+		indexes = append(indexes, e.Index)
+	}
+	if fmt.Sprint(indexes) != "[1 2 3]" {
+		t.Errorf("errors for entries %v, want [1 2 3]", indexes)
+	}
+
+	// the valid entry is not stored either
+	count, err := repo.GetMealCountByUser(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d meals stored from a rejected batch, want 0", count)
+	}
+}
+
+func TestHistoryPagination(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+	cookie := sessionCookie(t, s, user.Id)
+
+	// dish-24 is the newest, so it leads the first page
+	at := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+	meals := make([]repo.Meal, 25)
+	for i := range meals {
+		meals[i] = repo.NewMeal(fmt.Sprintf("dish-%02d", i), user.Id, repo.Lunch, at.Add(time.Duration(i)*time.Hour))
+	}
+	if _, err := repo.BulkInsertMeals(context.Background(), meals); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCount  int
+		wantMeals  []string // the first and last meal shown
+		wantNav    []string
+		notWantNav []string
+	}{
+		{"page 0", "page=0", http.StatusBadRequest, 0, nil, nil, nil},
+		{"non-numeric page", "page=two", http.StatusBadRequest, 0, nil, nil, nil},
+		{"per_page 0", "per_page=0", http.StatusBadRequest, 0, nil, nil, nil},
+		{
+			"first page", "page=1&per_page=10", http.StatusOK, 10,
+			[]string{"dish-24", "dish-15"},
+			[]string{"Page 1 of 3 (25 meals)", "page=2&per_page=10"},
+			[]string{"Previous"},
+		},
+		{
+			"last partial page", "page=3&per_page=10", http.StatusOK, 5,
+			[]string{"dish-04", "dish-00"},
+			[]string{"Page 3 of 3 (25 meals)", "page=2&per_page=10"},
+			[]string{"Next"},
+		},
+		{
+			"past the end", "page=5&per_page=10", http.StatusOK, 0,
+			nil,
+			[]string{"Page 5 of 3 (25 meals)", "page=3&per_page=10"},
+			[]string{"Next"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(s, httptest.NewRequest(http.MethodGet, "/history?"+tt.query, nil), cookie)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			body := rec.Body.String()
+			if n := strings.Count(body, "dish-"); n != tt.wantCount {
+				t.Errorf("page shows %d meals, want %d", n, tt.wantCount)
+			}
+			if tt.wantMeals != nil {
+				first, last := strings.Index(body, tt.wantMeals[0]), strings.Index(body, tt.wantMeals[1])
+				if first < 0 || last < 0 || first > last {
+					t.Errorf("want %s first and %s last", tt.wantMeals[0], tt.wantMeals[1])
+				}
+			}
+
+			for _, s := range tt.wantNav {
+				if !strings.Contains(body, s) {
+					t.Errorf("pagination is missing %q", s)
+				}
+			}
+			for _, s := range tt.notWantNav {
+				if strings.Contains(body, s) {
+					t.Errorf("pagination shows %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestMealSummary(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+	cookie := sessionCookie(t, s, user.Id)
+
+	summary := func() []repo.DailySummaryRow {
+		t.Helper()
+		rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/meals/summary", nil), cookie)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		var rows []repo.DailySummaryRow
+		if err := json.NewDecoder(rec.Body).Decode(&rows); err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+
+	if rows := summary(); rows == nil || len(rows) != 0 {
+		t.Errorf("summary without meals = %v, want []", rows)
+	}
+
+	// two meals a day for the last 60 days
+	today := time.Now()
+	var meals []repo.Meal
+	for day := 0; day < 60; day++ {
+		at := today.AddDate(0, 0, -day)
+		calories := 300.0
+		meals = append(meals,
+			repo.NewMealWithNutrition("porridge", user.Id, repo.Breakfast, at, &calories, nil, nil, nil),
+			repo.NewMealWithNutrition("soup", user.Id, repo.Lunch, at, &calories, nil, nil, nil),
+		)
+	}
+	if _, err := repo.BulkInsertMeals(context.Background(), meals); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := summary()
+	if len(rows) != SummaryLimit {
+		t.Fatalf("got %d days, want %d", len(rows), SummaryLimit)
+	}
+	for i, row := range rows {
+		want := today.AddDate(0, 0, -i).Format(repo.DateFormat)
+		if row.Date != want {
+			t.Errorf("day %d = %s, want %s", i, row.Date, want)
+		}
+		if row.MealCount != 2 || row.TotalCalories != 600 {
+			t.Errorf("%s has %d meals and %d kcal, want 2 and 600", row.Date, row.MealCount, row.TotalCalories)
+		}
+	}
+}
+
+func TestPprofDevMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		devMode bool
+		token   string
+		auth    string
+		want    int
+	}{
+		{"off outside dev mode", false, "", "", http.StatusNotFound},
+		{"on in dev mode", true, "", "", http.StatusOK},
+		{"token missing", true, "secret", "", http.StatusUnauthorized},
+		{"token wrong", true, "secret", "Bearer guess", http.StatusUnauthorized},
+		{"token given", true, "secret", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.DevMode = tt.devMode
+			cfg.PprofToken = tt.token
+			s := newTestServerWithConfig(t, cfg)
+			admin := newTestUser(t, "admin@example.com")
+			s.Config.AdminUserID = admin.Id
+
+			req := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := serve(s, req, sessionCookie(t, s, admin.Id))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileTemplatesMissingFile(t *testing.T) {
+	s := newTestServer(t)
+
+	view := append([]HTMLFile{}, TodayView...)
+	view = append(view, "templates/views/missing.html")
+
+	tmpl, err := s.CompileTemplates("today.html", view, nil)
+	if err == nil {
+		t.Fatal("CompileTemplates returned no error for a missing file")
+	}
+	if tmpl != nil {
+		t.Error("CompileTemplates returned a template along with its error")
+	}
+	if !strings.Contains(err.Error(), "today.html") {
+		t.Errorf("error %q does not name the view", err)
+	}
+
+	// a handler built from the broken view fails every request instead of panicking
+	rec := httptest.NewRecorder()
+	s.handleToday(view)(rec, httptest.NewRequest(http.MethodGet, "/today", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestTemplateExecutionError(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+
+	// compiles, but today's view data has no field named Missing
+	s.FileSystem = fstest.MapFS{
+		"broken.html": {Data: []byte(`{{ define "root" }}partial page {{ .Missing }}{{ end }}`)},
+	}
+	handler := s.handleToday([]HTMLFile{"broken.html"})
+
+	req := httptest.NewRequest(http.MethodGet, "/today", nil)
+	req = req.WithContext(context.WithValue(req.Context(), userIdContextKey{}, user.Id))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if strings.Contains(rec.Body.String(), "partial page") {
+		t.Error("the partly rendered page was sent")
+	}
+}
+
+func TestFlashShownOnce(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+	cookie := sessionCookie(t, s, user.Id)
+
+	// the flash lives in the session, so follow the cookie from each response
+	next := func(rec *httptest.ResponseRecorder) {
+		t.Helper()
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == SessionName {
+				cookie = c
+				return
+			}
+		}
+		t.Fatal("response did not update the session")
+	}
+
+	rec := serve(s, postForm("/api/meals", url.Values{"name": {"soup"}, "meal_type": {"lunch"}}), cookie)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+	next(rec)
+
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/today", nil), cookie)
+	if !strings.Contains(rec.Body.String(), "Meal added!") {
+		t.Fatal("flash not shown after adding a meal")
+	}
+	next(rec)
+
+	rec = serve(s, httptest.NewRequest(http.MethodGet, "/today", nil), cookie)
+	if strings.Contains(rec.Body.String(), "Meal added!") {
+		t.Error("flash shown again on reload")
+	}
+}
+
+func TestIndexRedirect(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	client := ts.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		want   string
+	}{
+		{"signed out", nil, "/login"},
+		{"signed in", sessionCookie(t, s, user.Id), "/today"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != http.StatusSeeOther {
+				t.Errorf("status = %d, want %d", res.StatusCode, http.StatusSeeOther)
+			}
+			if got := res.Header.Get("Location"); got != tt.want {
+				t.Errorf("Location = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// postMultipart builds a multipart POST carrying the test CSRF token, with a
+// photo file part when photo is not nil.
+func postMultipart(t *testing.T, path string, form url.Values, photo []byte) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	form.Set(CSRFFormField, testCSRFToken)
+	for name, values := range form {
+		for _, v := range values {
+			if err := mw.WriteField(name, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if photo != nil {
+		fw, err := mw.CreateFormFile("photo", "photo.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(photo)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestCreateMealEncodings(t *testing.T) {
+	// enough of a PNG for content sniffing
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	tests := []struct {
+		name      string
+		multipart bool
+		photo     []byte
+		htmx      bool
+	}{
+		{"url-encoded", false, nil, false},
+		{"url-encoded HTMX", false, nil, true},
+		{"multipart", true, nil, false},
+		{"multipart HTMX", true, nil, true},
+		{"multipart with photo", true, png, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			user := newTestUser(t, "a@b.com")
+
+			form := url.Values{"name": {"soup"}, "meal_type": {"lunch"}, "calories": {"250"}}
+			var req *http.Request
+			if tt.multipart {
+				req = postMultipart(t, "/api/meals", form, tt.photo)
+			} else {
+				req = postForm("/api/meals", form)
+			}
+			if tt.htmx {
+				req.Header.Set("HX-Request", "true")
+			}
+			rec := serve(s, req, sessionCookie(t, s, user.Id))
+
+			// HTMX gets today's table back, everything else is redirected to today
+			if tt.htmx {
+				if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<tbody id="meals-lunch">`) {
+					t.Errorf("status = %d, want %d with today's table: %.80q", rec.Code, http.StatusOK, rec.Body)
+				}
+			} else if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/today" {
+				t.Errorf("status = %d to %q, want %d to /today", rec.Code, rec.Header().Get("Location"), http.StatusSeeOther)
+			}
+
+			meals, err := repo.GetMealsByUserAndType(context.Background(), user, repo.Lunch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(meals) != 1 || meals[0].Name != "soup" || meals[0].Calories == nil || *meals[0].Calories != 250 {
+				t.Fatalf("lunches = %+v, want soup with 250 kcal", meals)
+			}
+			if hasPhoto := meals[0].PhotoPath != ""; hasPhoto != (tt.photo != nil) {
+				t.Errorf("photo path = %q, photo stored should be %v", meals[0].PhotoPath, tt.photo != nil)
+			}
+		})
+	}
+}
+
+func TestNotFoundPage(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, path := range []string{"/no-such-page", "/api/no-such-endpoint"} {
+		t.Run(path, func(t *testing.T) {
+			rec := serve(s, httptest.NewRequest(http.MethodGet, path, nil), nil)
+
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+			}
+			if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+				t.Errorf("Content-Type = %q, want text/html", got)
+			}
+
+			// error.html inside the site layout
+			body := rec.Body.String()
+			if !strings.Contains(body, "<h1>404 Not Found</h1>") || !strings.Contains(body, "<html") {
+				t.Errorf("body is not the error page: %.120q", body)
+			}
+		})
+	}
+}
+
+func TestFavicon(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/favicon.ico", nil), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "image/") {
+		t.Errorf("Content-Type = %q, want an image", got)
+	}
+	if got := http.DetectContentType(rec.Body.Bytes()); got != rec.Header().Get("Content-Type") {
+		t.Errorf("body sniffs as %q, not the Content-Type sent", got)
+	}
+}
+
+func TestExportJSONRoundTrip(t *testing.T) {
+	s := newTestServer(t)
+	user := newTestUser(t, "a@b.com")
+
+	calories, protein := 250.0, 12.5
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.Local)
+	if _, err := repo.BulkInsertMeals(context.Background(), []repo.Meal{
+		repo.NewMealWithNutrition("soup", user.Id, repo.Lunch, at, &calories, &protein, nil, nil,
+			repo.WithNotes(`at the "corner" café`), repo.WithQuantity(2, "bowl")),
+		repo.NewMeal("toast", user.Id, repo.Breakfast, at.AddDate(0, 0, 1)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(s, httptest.NewRequest(http.MethodGet, "/api/meals/export.json", nil), sessionCookie(t, s, user.Id))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var got []repo.Meal
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := repo.GetAllMealsByUser(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("exported %d meals, want 2", len(got))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("exported meals do not match the stored ones:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestGetMeal(t *testing.T) {
+	s := newTestServer(t)
+	owner := newTestUser(t, "a@b.com")
+	other := newTestUser(t, "c@d.com")
+
+	meal, err := repo.InsertMeal(context.Background(), repo.NewMeal("soup", owner.Id, repo.Lunch, time.Now(), repo.WithTags("warm")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.FormatInt(meal.Id, 10)
+
+	tests := []struct {
+		name string
+		user int64
+		path string
+		want int
+	}{
+		{"found", owner.Id, "/api/meals/" + id, http.StatusOK},
+		{"not found", owner.Id, "/api/meals/" + strconv.FormatInt(meal.Id+1, 10), http.StatusNotFound},
+		{"not a number", owner.Id, "/api/meals/soup", http.StatusNotFound},
+		{"wrong user", other.Id, "/api/meals/" + id, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(s, httptest.NewRequest(http.MethodGet, tt.path, nil), sessionCookie(t, s, tt.user))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var got repo.Meal
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Id != meal.Id || got.Name != "soup" || !reflect.DeepEqual(got.Tags, []string{"warm"}) {
+				t.Errorf("got meal %d %q tagged %v, want %d soup tagged [warm]", got.Id, got.Name, got.Tags, meal.Id)
+			}
+		})
+	}
+}
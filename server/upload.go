@@ -0,0 +1,139 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const MaxPhotoSize = 5 << 20 // 5 MB
+
+var (
+	ErrInvalidUploadPath = errors.New("invalid upload path")
+	ErrPhotoTooLarge     = fmt.Errorf("photo must be at most %d MB", MaxPhotoSize>>20)
+	ErrPhotoType         = errors.New("photo must be a JPEG or PNG image")
+)
+
+// photoExts maps the image types accepted for meal photos to their extension.
+var photoExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// ReadPhoto reads an uploaded meal photo and returns its contents and file
+// extension. The type is sniffed from the contents rather than trusting the
+// Content-Type the client sent.
+func ReadPhoto(fh *multipart.FileHeader) ([]byte, string, error) {
+	if fh.Size > MaxPhotoSize {
+		return nil, "", ErrPhotoTooLarge
+	}
+
+	file, err := fh.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, MaxPhotoSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > MaxPhotoSize {
+		return nil, "", ErrPhotoTooLarge
+	}
+
+	ext, ok := photoExts[http.DetectContentType(data)]
+	if !ok {
+		return nil, "", ErrPhotoType
+	}
+
+	return data, ext, nil
+}
+
+// SaveMealPhoto stores a photo read by ReadPhoto as uploads/{userId}/{mealId}.{ext}
+// and returns the URL it is served from.
+func SaveMealPhoto(uploadDir string, userId, mealId int64, data []byte, ext string) (string, error) {
+	subpath := fmt.Sprintf("%d/%d%s", userId, mealId, ext)
+	if _, err := SaveUpload(uploadDir, subpath, data); err != nil {
+		return "", err
+	}
+
+	return "/uploads/" + subpath, nil
+}
+
+// SaveUpload writes data to subpath inside uploadDir and returns the full path.
+// subpath must be relative and must not contain ".." components. The file is
+// written to a temporary file first and renamed into place, so readers never
+// see a partial upload.
+func SaveUpload(uploadDir, subpath string, data []byte) (string, error) {
+	if subpath == "" || filepath.IsAbs(subpath) || strings.HasPrefix(subpath, "/") {
+		return "", ErrInvalidUploadPath
+	}
+
+	for _, part := range strings.FieldsFunc(subpath, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return "", ErrInvalidUploadPath
+		}
+	}
+
+	path := filepath.Join(uploadDir, filepath.FromSlash(subpath))
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// userUploadDir is where SaveMealPhoto stores the user's files.
+func userUploadDir(uploadDir string, userId int64) string {
+	return filepath.Join(uploadDir, strconv.FormatInt(userId, 10))
+}
+
+// RemoveMealPhoto deletes the file behind a URL returned by SaveMealPhoto. A
+// photo that is already gone is not an error.
+func RemoveMealPhoto(uploadDir, photoPath string) error {
+	subpath, ok := strings.CutPrefix(photoPath, "/uploads/")
+	if !ok || subpath == "" {
+		return ErrInvalidUploadPath
+	}
+
+	// path.Clean with a leading slash drops any ".." that would climb out of uploadDir
+	file := filepath.Join(uploadDir, filepath.FromSlash(path.Clean("/"+subpath)))
+	if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// RemoveUserUploads deletes every file the user uploaded.
+func RemoveUserUploads(uploadDir string, userId int64) error {
+	return os.RemoveAll(userUploadDir(uploadDir, userId))
+}
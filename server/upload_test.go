@@ -0,0 +1,67 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveUploadRejectsTraversal(t *testing.T) {
+	root := t.TempDir()
+	uploadDir := filepath.Join(root, "uploads")
+
+	for _, subpath := range []string{
+		"../../etc/passwd",
+		"../outside.jpg",
+		"1/../../outside.jpg",
+		`1\..\..\outside.jpg`,
+		"/etc/passwd",
+		"",
+	} {
+		t.Run(subpath, func(t *testing.T) {
+			if _, err := SaveUpload(uploadDir, subpath, []byte("data")); !errors.Is(err, ErrInvalidUploadPath) {
+				t.Errorf("err = %v, want ErrInvalidUploadPath", err)
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "outside.jpg")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a file was written outside the upload directory: %v", err)
+	}
+}
+
+func TestUploadsOtherUser(t *testing.T) {
+	s := newTestServer(t)
+	alice := newTestUser(t, "alice@example.com")
+	bob := newTestUser(t, "bob@example.com")
+
+	photo := fmt.Sprintf("%d/1.jpg", alice.Id)
+	if _, err := SaveUpload(s.Config.UploadDir, photo, []byte("photo")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		user int64
+		path string
+		want int
+	}{
+		{"owner", alice.Id, "/uploads/" + photo, http.StatusOK},
+		{"other user", bob.Id, "/uploads/" + photo, http.StatusNotFound},
+		{"other user via ..", bob.Id, fmt.Sprintf("/uploads/%d/../%s", bob.Id, photo), http.StatusNotFound},
+		{"other user's directory", bob.Id, fmt.Sprintf("/uploads/%d/", alice.Id), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(s, httptest.NewRequest(http.MethodGet, tt.path, nil), sessionCookie(t, s, tt.user))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -1,21 +1,321 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
 
+	"github.com/connorkuljis/food-diary/repo"
 	"github.com/gorilla/sessions"
 )
 
+const (
+	SessionName = "session" // name of the cookie holding the session
+	userIdKey   = "userId"  // session value holding the signed in user's id
+)
+
+// FlashData is a one-time notice shown on the next rendered page.
+type FlashData struct {
+	Message  string
+	Category string // one of FlashSuccess, FlashError or FlashWarning
+}
+
+// MealSection is one meal type's rows of today's table, which HTMX deletes
+// re-render on their own.
+type MealSection struct {
+	MealType repo.MealType
+	Meals    []repo.Meal
+}
+
+// mealSections splits meals into a section for each of repo.MealTypes, in that
+// order, keeping the order of the meals within each.
+func mealSections(meals []repo.Meal) []MealSection {
+	sections := make([]MealSection, len(repo.MealTypes))
+	for i, mealType := range repo.MealTypes {
+		sections[i].MealType = mealType
+		for _, meal := range meals {
+			if repo.MealType(meal.MealType) == mealType {
+				sections[i].Meals = append(sections[i].Meals, meal)
+			}
+		}
+	}
+	return sections
+}
+
+// defaultMealType picks the meal type the today form starts on: the type of
+// the user's most frequent meal, which is the first of frequent as returned by
+// GetMostFrequentMeals, or breakfast before any meal has been logged.
+func defaultMealType(frequent []repo.FrequentMeal) repo.MealType {
+	if len(frequent) == 0 || !repo.IsValidMealType(frequent[0].MealType) {
+		return repo.Breakfast
+	}
+	return frequent[0].MealType
+}
+
+// DayOfWeekBar is one bar of the day of week calorie chart.
+type DayOfWeekBar struct {
+	Day      string  // short weekday name, such as "Mon"
+	Calories float64 // average daily calories on that weekday
+	Percent  int     // height of the bar relative to the highest day
+}
+
+// dayOfWeekBars lays out averages, indexed by time.Weekday, as chart bars
+// scaled to the highest average.
+func dayOfWeekBars(averages [7]float64) []DayOfWeekBar {
+	var highest float64
+	for _, avg := range averages {
+		highest = max(highest, avg)
+	}
+
+	bars := make([]DayOfWeekBar, len(averages))
+	for dow, avg := range averages {
+		bars[dow] = DayOfWeekBar{Day: time.Weekday(dow).String()[:3], Calories: avg}
+		if highest > 0 {
+			bars[dow].Percent = int(avg / highest * 100)
+		}
+	}
+	return bars
+}
+
+const (
+	FlashSuccess = "success"
+	FlashError   = "error"
+	FlashWarning = "warning"
+
+	flashKey         = "flash"
+	flashCategoryKey = "flash_category"
+)
+
+// SetFlash stores a message in the session to be shown after the next redirect.
+func SetFlash(r *http.Request, w http.ResponseWriter, s *sessions.CookieStore, msg, category string) error {
+	switch category {
+	case FlashSuccess, FlashError, FlashWarning:
+	default:
+		return fmt.Errorf("unknown flash category %q", category)
+	}
+
+	session, _ := s.Get(r, SessionName)
+	session.AddFlash(msg, flashKey)
+	session.AddFlash(category, flashCategoryKey)
+
+	return session.Save(r, w)
+}
+
+// GetFlash removes and returns the pending flash message, if any. The removal
+// only sticks once the session is saved, so callers must save it before
+// writing the response.
+func GetFlash(r *http.Request, s *sessions.CookieStore) (msg, category string, err error) {
+	session, err := s.Get(r, SessionName)
+	if err != nil {
+		return "", "", err
+	}
+
+	msgs := session.Flashes(flashKey)
+	categories := session.Flashes(flashCategoryKey)
+	if len(msgs) == 0 {
+		return "", "", nil
+	}
+
+	msg, _ = msgs[len(msgs)-1].(string)
+	if len(categories) > 0 {
+		category, _ = categories[len(categories)-1].(string)
+	}
+
+	return msg, category, nil
+}
+
+// GetUserId returns the signed in user's id. Behind AuthMiddleware it is read
+// from the request context, otherwise from the session cookie.
 func GetUserId(r *http.Request, s *sessions.CookieStore) (int64, error) {
-	const key = "userId"
+	if id, ok := r.Context().Value(userIdContextKey{}).(int64); ok {
+		return id, nil
+	}
 
-	session, _ := s.Get(r, "session")
+	session, _ := s.Get(r, SessionName)
 
-	id, ok := session.Values[key].(int64)
-	if !ok {
+	// ids are stored as int64, but accept an int in case a session was
+	// written or decoded with a different type
+	switch id := session.Values[userIdKey].(type) {
+	case int64:
+		return id, nil
+	case int:
+		return int64(id), nil
+	default:
 		return 0, errors.New("Error! Could not get user id from session")
 	}
+}
+
+// resetSession clears every value in the session, gives it a new CSRF token
+// and records the client signing in from r for the session binding checked by
+// AuthMiddleware. Call it when the user signs in so the session cannot be
+// fixed in advance.
+func resetSession(session *sessions.Session, r *http.Request) error {
+	token, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+
+	for key := range session.Values {
+		delete(session.Values, key)
+	}
+	session.Values[csrfKey] = token
+	session.Values[sessionIPKey] = clientIP(r)
+	session.Values[sessionUAKey] = r.UserAgent()
+
+	return nil
+}
+
+// isValidEmail reports whether email is a bare address such as
+// "me@example.com". Display name forms like "Me <me@example.com>" are
+// rejected as they are not what we store.
+func isValidEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+const (
+	MinPasswordLength = 8
+	MaxPasswordBytes  = 72 // bcrypt ignores anything past this
+)
+
+// validatePassword checks a new password has enough characters, and few enough
+// bytes that bcrypt does not silently truncate it.
+func validatePassword(p string) error {
+	if utf8.RuneCountInString(p) < MinPasswordLength {
+		return fmt.Errorf("Password must be at least %d characters", MinPasswordLength)
+	}
+	if len(p) > MaxPasswordBytes {
+		return fmt.Errorf("Password must be at most %d bytes", MaxPasswordBytes)
+	}
+
+	return nil
+}
+
+// RenderTemplate executes the named template into a buffer before writing it
+// to w. If execution fails nothing has been sent yet and the caller is still
+// free to respond with an error status.
+func RenderTemplate(w http.ResponseWriter, tmpl *template.Template, name string, data any) error {
+	return RenderTemplateStatus(w, http.StatusOK, tmpl, name, data)
+}
+
+// RenderTemplateStatus is RenderTemplate with a status code other than 200.
+func RenderTemplateStatus(w http.ResponseWriter, status int, tmpl *template.Template, name string, data any) error {
+	page, err := RenderToBuffer(tmpl, name, data)
+	if err != nil {
+		return err
+	}
+
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.WriteHeader(status)
+	w.Write(page)
+	return nil
+}
+
+// RenderToBuffer executes the named template and returns the output, so that
+// a failure halfway through never reaches the client as a truncated page.
+func RenderToBuffer(tmpl *template.Template, name string, data any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// SafeRedirect returns redirect when it is a local path on this site, otherwise
+// fallback. Absolute and protocol-relative URLs are rejected so that a crafted
+// ?redirect= parameter cannot send users to another host.
+func SafeRedirect(redirect, fallback string) string {
+	if redirect == "" || !strings.HasPrefix(redirect, "/") {
+		return fallback
+	}
+
+	// "//host" and "/\host" are treated as protocol-relative by browsers
+	if strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return fallback
+	}
+
+	u, err := url.Parse(redirect)
+	if err != nil || u.IsAbs() || u.Host != "" {
+		return fallback
+	}
+
+	return redirect
+}
+
+// LoginRedirect builds the login url that returns the user to r once authenticated.
+func LoginRedirect(r *http.Request) string {
+	return "/login?redirect=" + url.QueryEscape(r.URL.RequestURI())
+}
+
+// WriteJSON encodes v as the JSON response body with the given status code.
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// IsMultipart reports whether the request body is multipart/form-data.
+func IsMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// BaseURL returns the scheme and host the request was made to, e.g. "https://example.com".
+func BaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// ParseOptionalFloat parses a form value that may be left blank, returning nil when it is.
+func ParseOptionalFloat(value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// PositiveIntParam reads a positive integer query parameter, returning def
+// when it is absent and an error when it is not a positive integer.
+func PositiveIntParam(r *http.Request, name string, def int) (int, error) {
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(str)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+
+	return n, nil
+}
 
-	return id, nil
+// StartOfWeek returns midnight on the Monday of t's week.
+func StartOfWeek(t time.Time) time.Time {
+	// time.Weekday starts on Sunday
+	offset := (int(t.Weekday()) + 6) % 7
+	y, m, d := t.AddDate(0, 0, -offset).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
 }
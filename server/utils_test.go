@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestIsValidEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"a@b.com", true},
+		{"first.last+tag@example.co.uk", true},
+		{"", false},
+		{"a", false},
+		{"a@", false},
+		{"@b.com", false},
+		{"a b@c.com", false},
+		{"Alice <a@b.com>", false},
+		{" a@b.com", false},
+		{"a@b.com ", false},
+	}
+
+	for _, tt := range tests {
+		if got := isValidEmail(tt.email); got != tt.want {
+			t.Errorf("isValidEmail(%q) = %v, want %v", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{"empty", "", true},
+		{"7 characters", strings.Repeat("a", 7), true},
+		{"8 characters", strings.Repeat("a", 8), false},
+		{"72 bytes", strings.Repeat("a", 72), false},
+		{"73 bytes", strings.Repeat("a", 73), true},
+		// é is two bytes, the minimum counts characters
+		{"8 two byte characters", strings.Repeat("é", 8), false},
+		{"7 two byte characters", strings.Repeat("é", 7), true},
+		{"74 bytes of two byte characters", strings.Repeat("é", 37), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePassword(tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validatePassword(%d bytes) = %v, want error %v", len(tt.password), err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetUserIdCookieRoundTrip(t *testing.T) {
+	store := sessions.NewCookieStore([]byte("test-session-secret"))
+
+	tests := []struct {
+		name    string
+		stored  any
+		want    int64
+		wantErr bool
+	}{
+		{"int64", int64(42), 42, false},
+		{"int", 42, 42, false},
+		{"missing", nil, 0, true},
+		{"wrong type", "42", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// encode the session into a cookie, as a response would
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			session, err := store.New(req, SessionName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.stored != nil {
+				session.Values[userIdKey] = tt.stored
+			}
+			rec := httptest.NewRecorder()
+			if err := session.Save(req, rec); err != nil {
+				t.Fatal(err)
+			}
+
+			// and decode it from the next request
+			next := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, cookie := range rec.Result().Cookies() {
+				next.AddCookie(cookie)
+			}
+
+			got, err := GetUserId(next, store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetUserId error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetUserId = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafeRedirect(t *testing.T) {
+	tests := []struct {
+		redirect string
+		want     string
+	}{
+		{"/evil", "/evil"},
+		{"/history?page=2", "/history?page=2"},
+		{"https://evil.com", "/today"},
+		{"//evil.com", "/today"},
+		{"/\\evil.com", "/today"},
+		{"evil.com", "/today"},
+		{"", "/today"},
+	}
+
+	for _, tt := range tests {
+		if got := SafeRedirect(tt.redirect, "/today"); got != tt.want {
+			t.Errorf("SafeRedirect(%q) = %q, want %q", tt.redirect, got, tt.want)
+		}
+	}
+}
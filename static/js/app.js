@@ -0,0 +1,18 @@
+// Behaviour that would otherwise need inline scripts, which the
+// Content-Security-Policy blocks.
+
+// forms marked data-reset-on-success are cleared once HTMX has submitted them
+document.addEventListener("htmx:afterRequest", function (event) {
+  var form = event.detail.elt;
+  if (event.detail.successful && form.matches("form[data-reset-on-success]")) {
+    form.reset();
+  }
+});
+
+// inputs marked data-food-search send what has been typed so far as ?q=
+document.addEventListener("htmx:configRequest", function (event) {
+  var input = event.detail.elt;
+  if (input.matches("input[data-food-search]")) {
+    event.detail.parameters.q = input.value;
+  }
+});